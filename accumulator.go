@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdb/influxdb/client/v2"
@@ -27,7 +28,7 @@ type Accumulator interface {
 
 func NewAccumulator(
 	plugin *ConfiguredPlugin,
-	points chan *client.Point,
+	points *pointChannel,
 ) Accumulator {
 	acc := accumulator{}
 	acc.points = points
@@ -38,7 +39,7 @@ func NewAccumulator(
 type accumulator struct {
 	sync.Mutex
 
-	points chan *client.Point
+	points *pointChannel
 
 	defaultTags map[string]string
 
@@ -111,11 +112,12 @@ func (ac *accumulator) AddFields(
 	pt, err := client.NewPoint(measurement, tags, fields, timestamp)
 	if err != nil {
 		log.Printf("Error adding point [%s]: %s\n", measurement, err.Error())
+		return
 	}
 	if ac.debug {
 		fmt.Println("> " + pt.String())
 	}
-	ac.points <- pt
+	ac.points.send(pt)
 }
 
 func (ac *accumulator) SetDefaultTags(tags map[string]string) {
@@ -141,3 +143,53 @@ func (ac *accumulator) Debug() bool {
 func (ac *accumulator) SetDebug(debug bool) {
 	ac.debug = debug
 }
+
+// lateAccumulator passes the points on, counting those added after the
+// gather was reported as timed out.
+type lateAccumulator struct {
+	Accumulator
+
+	// Accessed atomically
+	isLate int32
+	nLate  int64
+}
+
+func (a *lateAccumulator) Add(
+	measurement string,
+	value interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	a.count()
+	a.Accumulator.Add(measurement, value, tags, t...)
+}
+
+func (a *lateAccumulator) AddFields(
+	measurement string,
+	fields map[string]interface{},
+	tags map[string]string,
+	t ...time.Time,
+) {
+	a.count()
+	a.Accumulator.AddFields(measurement, fields, tags, t...)
+}
+
+func (a *lateAccumulator) count() {
+	if atomic.LoadInt32(&a.isLate) != 0 {
+		atomic.AddInt64(&a.nLate, 1)
+	}
+}
+
+// setLate counts the points added from now on as late.
+func (a *lateAccumulator) setLate() {
+	atomic.StoreInt32(&a.isLate, 1)
+}
+
+func (a *lateAccumulator) late() bool {
+	return atomic.LoadInt32(&a.isLate) != 0
+}
+
+// latePoints returns the number of points added since setLate.
+func (a *lateAccumulator) latePoints() int64 {
+	return atomic.LoadInt64(&a.nLate)
+}
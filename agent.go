@@ -9,24 +9,100 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/influxdb/telegraf/internal"
+	"github.com/influxdb/telegraf/internal/selfstat"
 	"github.com/influxdb/telegraf/outputs"
 	"github.com/influxdb/telegraf/plugins"
 
 	"github.com/influxdb/influxdb/client/v2"
 )
 
-type runningOutput struct {
-	name   string
-	output outputs.Output
-}
-
 type runningPlugin struct {
+	// name is the instance ID of the plugin, its base name is in config
 	name   string
 	plugin plugins.Plugin
 	config *ConfiguredPlugin
+
+	// running is set while a gather is running, accessed atomically
+	running int32
+
+	// Self statistics, reported by the internal plugin
+	gatherTime     *selfstat.Stat
+	gatherErrors   *selfstat.Stat
+	gatherTimeouts *selfstat.Stat
+	gatherSkipped  *selfstat.Stat
+	gatherLate     *selfstat.Stat
+}
+
+func newRunningPlugin(
+	name string,
+	plugin plugins.Plugin,
+	config *ConfiguredPlugin,
+) *runningPlugin {
+	tags := map[string]string{"plugin": name}
+	return &runningPlugin{
+		name:           name,
+		plugin:         plugin,
+		config:         config,
+		gatherTime:     selfstat.Register("gather", "gather_time_ns", tags),
+		gatherErrors:   selfstat.Register("gather", "errors", tags),
+		gatherTimeouts: selfstat.Register("gather", "timeouts", tags),
+		gatherSkipped:  selfstat.Register("gather", "skipped", tags),
+		gatherLate:     selfstat.Register("gather", "late_points", tags),
+	}
+}
+
+// gather runs the plugin once, logging and counting its errors. If the
+// plugin has a timeout, a gather taking longer carries on in the background,
+// its later points are still passed on but counted as late, and the gathers
+// started before it finishes are skipped.
+func (rp *runningPlugin) gather(acc Accumulator) {
+	if !atomic.CompareAndSwapInt32(&rp.running, 0, 1) {
+		log.Printf("Skipping plugin [%s]: previous gather still running\n",
+			rp.name)
+		rp.gatherSkipped.Incr(1)
+		return
+	}
+
+	lateAcc := &lateAccumulator{Accumulator: acc}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer atomic.StoreInt32(&rp.running, 0)
+
+		start := time.Now()
+		if err := rp.plugin.Gather(lateAcc); err != nil {
+			log.Printf("Error in plugin [%s]: %s", rp.name, err)
+			rp.gatherErrors.Incr(1)
+		}
+		elapsed := time.Since(start)
+		rp.gatherTime.Set(elapsed.Nanoseconds())
+		if lateAcc.late() {
+			late := lateAcc.latePoints()
+			rp.gatherLate.Incr(late)
+			log.Printf("Plugin [%s] finished its gather in %s, after its "+
+				"timeout, with %d late points\n", rp.name, elapsed, late)
+		}
+	}()
+
+	timeout := rp.config.Timeout
+	if timeout == 0 {
+		<-done
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		lateAcc.setLate()
+		log.Printf("Error in plugin [%s]: gather took longer than %s, "+
+			"carrying on without it\n", rp.name, timeout)
+		rp.gatherTimeouts.Incr(1)
+	}
 }
 
 // Agent runs telegraf and collects data based on the given config
@@ -42,7 +118,9 @@ type Agent struct {
 	// Interval at which to flush data
 	FlushInterval internal.Duration
 
-	// FlushRetries is the number of times to retry each data flush
+	// FlushRetries is no longer used: outputs keep the points they fail to
+	// write in their buffer and retry them at their next flush. It is kept
+	// so that existing configs still load.
 	FlushRetries int
 
 	// FlushJitter tells
@@ -59,6 +137,13 @@ type Agent struct {
 	// Valid values for Precision are n, u, ms, s, m, and h
 	Precision string
 
+	// PointBufferSize is the number of gathered points held until they are
+	// handed to the outputs.
+	PointBufferSize int
+	// PointBufferPolicy is what happens to points gathered while the point
+	// buffer is full: "block" the plugins, "drop_newest" or "drop_oldest".
+	PointBufferPolicy string
+
 	// Option for running in debug mode
 	Debug    bool
 	Hostname string
@@ -67,6 +152,9 @@ type Agent struct {
 
 	outputs []*runningOutput
 	plugins []*runningPlugin
+
+	// pointChan carries the gathered points while running
+	pointChan *pointChannel
 }
 
 // NewAgent returns an Agent struct based off the given Config
@@ -78,6 +166,9 @@ func NewAgent(config *Config) (*Agent, error) {
 		FlushInterval: internal.Duration{10 * time.Second},
 		FlushRetries:  2,
 		FlushJitter:   internal.Duration{5 * time.Second},
+
+		PointBufferSize:   1000,
+		PointBufferPolicy: policyBlock,
 	}
 
 	// Apply the toml table to the agent config, overriding defaults
@@ -86,6 +177,10 @@ func NewAgent(config *Config) (*Agent, error) {
 		return nil, err
 	}
 
+	if err := validatePointPolicy(agent.PointBufferPolicy); err != nil {
+		return nil, err
+	}
+
 	if agent.Hostname == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -161,7 +256,12 @@ func (a *Agent) LoadOutputs(filters []string, config *Config) ([]string, error)
 				return nil, err
 			}
 
-			a.outputs = append(a.outputs, &runningOutput{name, output})
+			ro, err := newRunningOutput(name, output,
+				config.GetOutputConfig(name))
+			if err != nil {
+				return nil, err
+			}
+			a.outputs = append(a.outputs, ro)
 			names = append(names, name)
 		}
 	}
@@ -175,11 +275,19 @@ func (a *Agent) LoadOutputs(filters []string, config *Config) ([]string, error)
 func (a *Agent) LoadPlugins(filters []string, config *Config) ([]string, error) {
 	var names []string
 
-	for name, plugin := range config.PluginsDeclared() {
-		if sliceContains(name, filters) || len(filters) == 0 {
-			config := config.GetPluginConfig(name)
-			a.plugins = append(a.plugins, &runningPlugin{name, plugin, config})
-			names = append(names, name)
+	for id, plugin := range config.PluginsDeclared() {
+		// Filter on the plugin name, so that a filter selects every instance
+		pluginConfig := config.GetPluginConfig(id)
+		if sliceContains(pluginConfig.Name, filters) || len(filters) == 0 {
+			if v, ok := plugin.(plugins.Validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, fmt.Errorf("Error in plugin [%s]: %s", id, err)
+				}
+			}
+
+			a.plugins = append(a.plugins,
+				newRunningPlugin(id, plugin, pluginConfig))
+			names = append(names, id)
 		}
 	}
 
@@ -189,8 +297,8 @@ func (a *Agent) LoadPlugins(filters []string, config *Config) ([]string, error)
 }
 
 // gatherParallel runs the plugins that are using the same reporting interval
-// as the telegraf agent.
-func (a *Agent) gatherParallel(pointChan chan *client.Point) error {
+// as the telegraf agent, waiting for each at most its timeout.
+func (a *Agent) gatherParallel(pointChan *pointChannel) error {
 	var wg sync.WaitGroup
 
 	start := time.Now()
@@ -207,13 +315,10 @@ func (a *Agent) gatherParallel(pointChan chan *client.Point) error {
 
 			acc := NewAccumulator(plugin.config, pointChan)
 			acc.SetDebug(a.Debug)
-			acc.SetPrefix(plugin.name + "_")
+			acc.SetPrefix(plugin.config.Name + "_")
 			acc.SetDefaultTags(a.Tags)
 
-			if err := plugin.plugin.Gather(acc); err != nil {
-				log.Printf("Error in plugin [%s]: %s", plugin.name, err)
-			}
-
+			plugin.gather(acc)
 		}(plugin)
 	}
 
@@ -230,7 +335,7 @@ func (a *Agent) gatherParallel(pointChan chan *client.Point) error {
 func (a *Agent) gatherSeparate(
 	shutdown chan struct{},
 	plugin *runningPlugin,
-	pointChan chan *client.Point,
+	pointChan *pointChannel,
 ) error {
 	ticker := time.NewTicker(plugin.config.Interval)
 
@@ -240,12 +345,10 @@ func (a *Agent) gatherSeparate(
 
 		acc := NewAccumulator(plugin.config, pointChan)
 		acc.SetDebug(a.Debug)
-		acc.SetPrefix(plugin.name + "_")
+		acc.SetPrefix(plugin.config.Name + "_")
 		acc.SetDefaultTags(a.Tags)
 
-		if err := plugin.plugin.Gather(acc); err != nil {
-			log.Printf("Error in plugin [%s]: %s", plugin.name, err)
-		}
+		plugin.gather(acc)
 
 		elapsed := time.Since(start)
 		log.Printf("Gathered metrics, (separate %s interval), from %s in %s\n",
@@ -269,13 +372,16 @@ func (a *Agent) gatherSeparate(
 func (a *Agent) Test() error {
 	shutdown := make(chan struct{})
 	defer close(shutdown)
-	pointChan := make(chan *client.Point)
+	pointChan := &pointChannel{
+		points: make(chan *client.Point),
+		policy: policyBlock,
+	}
 
 	// dummy receiver for the point channel
 	go func() {
 		for {
 			select {
-			case <-pointChan:
+			case <-pointChan.points:
 				// do nothing
 			case <-shutdown:
 				return
@@ -286,7 +392,7 @@ func (a *Agent) Test() error {
 	for _, plugin := range a.plugins {
 		acc := NewAccumulator(plugin.config, pointChan)
 		acc.SetDebug(true)
-		acc.SetPrefix(plugin.name + "_")
+		acc.SetPrefix(plugin.config.Name + "_")
 
 		fmt.Printf("* Plugin: %s, Collection 1\n", plugin.name)
 		if plugin.config.Interval != 0 {
@@ -299,7 +405,7 @@ func (a *Agent) Test() error {
 
 		// Special instructions for some plugins. cpu, for example, needs to be
 		// run twice in order to return cpu usage percentages.
-		switch plugin.name {
+		switch plugin.config.Name {
 		case "cpu", "mongodb":
 			time.Sleep(500 * time.Millisecond)
 			fmt.Printf("* Plugin: %s, Collection 2\n", plugin.name)
@@ -312,94 +418,278 @@ func (a *Agent) Test() error {
 	return nil
 }
 
-// writeOutput writes a list of points to a single output, with retries.
-// Optionally takes a `done` channel to indicate that it is done writing.
-func (a *Agent) writeOutput(
-	points []*client.Point,
-	ro *runningOutput,
-	shutdown chan struct{},
-	wg *sync.WaitGroup,
-) {
-	defer wg.Done()
-	if len(points) == 0 {
-		return
+// backfillBatchSize is the number of backfilled points written to the outputs
+// at once.
+const backfillBatchSize = 1000
+
+// Backfill gathers the range from start to end from every instance of the
+// plugin called name, or from the instance with that ID, and writes the points
+// to all outputs as they arrive. It fails if an output did not write all the
+// points, so that the range can be backfilled again.
+func (a *Agent) Backfill(name string, start, end time.Time) error {
+	dropped := make([]int64, len(a.outputs))
+	for i, o := range a.outputs {
+		dropped[i] = o.dropped()
 	}
-	retry := 0
-	retries := a.FlushRetries
-	start := time.Now()
 
-	for {
-		err := ro.output.Write(points)
-		if err == nil {
-			// Write successful
-			elapsed := time.Since(start)
-			log.Printf("Flushed %d metrics to output %s in %s\n",
-				len(points), ro.name, elapsed)
-			return
+	found := false
+	for _, plugin := range a.plugins {
+		if plugin.name != name && plugin.config.Name != name {
+			continue
 		}
+		found = true
 
-		select {
-		case <-shutdown:
-			return
-		default:
-			if retry >= retries {
-				// No more retries
-				msg := "FATAL: Write to output [%s] failed %d times, dropping" +
-					" %d metrics\n"
-				log.Printf(msg, ro.name, retries+1, len(points))
-				return
-			} else if err != nil {
-				// Sleep for a retry
-				log.Printf("Error in output [%s]: %s, retrying in %s",
-					ro.name, err.Error(), a.FlushInterval.Duration)
-				time.Sleep(a.FlushInterval.Duration)
+		backfiller, ok := plugin.plugin.(plugins.Backfiller)
+		if !ok {
+			return fmt.Errorf("Plugin [%s] does not support backfilling",
+				plugin.name)
+		}
+		if err := a.backfill(plugin, backfiller, start, end); err != nil {
+			return fmt.Errorf("Error in plugin [%s]: %s", plugin.name, err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("Plugin [%s] is not loaded", name)
+	}
+
+	var errs []string
+	for i, o := range a.outputs {
+		buffered, lost := o.buffered(), o.dropped()-dropped[i]
+		if buffered != 0 || lost != 0 {
+			errs = append(errs, fmt.Sprintf("output [%s] has %d metrics "+
+				"unwritten and dropped %d", o.name, buffered, lost))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("Backfill incomplete: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func (a *Agent) backfill(
+	plugin *runningPlugin,
+	backfiller plugins.Backfiller,
+	start, end time.Time,
+) error {
+	pointChan := &pointChannel{
+		points: make(chan *client.Point),
+		policy: policyBlock,
+	}
+
+	// write the points in batches, blocking the plugin while the outputs
+	// catch up
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		points := make([]*client.Point, 0, backfillBatchSize)
+		for pt := range pointChan.points {
+			points = append(points, pt)
+			if len(points) == backfillBatchSize {
+				a.flush(points)
+				points = make([]*client.Point, 0, backfillBatchSize)
 			}
 		}
+		a.flush(points)
+	}()
+
+	acc := NewAccumulator(plugin.config, pointChan)
+	acc.SetDebug(a.Debug)
+	acc.SetPrefix(plugin.config.Name + "_")
+	acc.SetDefaultTags(a.Tags)
+
+	err := backfiller.Backfill(acc, start, end)
+	pointChan.close()
+	<-done
+	return err
+}
+
+// bufferStats returns a point per output with a disk buffer, describing what
+// it holds.
+func (a *Agent) bufferStats() []*client.Point {
+	var points []*client.Point
+	for _, ro := range a.outputs {
+		if ro.queue == nil {
+			continue
+		}
+		stats := ro.queue.Stats()
 
-		retry++
+		tags := map[string]string{"output": ro.name}
+		for k, v := range a.Tags {
+			tags[k] = v
+		}
+		fields := map[string]interface{}{
+			"batches":        stats.Batches,
+			"points":         stats.Points,
+			"size_bytes":     stats.Bytes,
+			"evicted_points": stats.Evicted,
+		}
+		pt, err := client.NewPoint("telegraf_disk_buffer", tags, fields,
+			time.Now())
+		if err != nil {
+			log.Printf("Error in output [%s]: %s\n", ro.name, err)
+			continue
+		}
+		points = append(points, pt)
 	}
+	return points
 }
 
-// flush writes a list of points to all configured outputs
-func (a *Agent) flush(
-	points []*client.Point,
-	shutdown chan struct{},
-	wait bool,
-) {
+// flush adds a list of points to all configured outputs and writes them,
+// waiting for every output to be done.
+func (a *Agent) flush(points []*client.Point) {
+	shutdown := make(chan struct{})
 	var wg sync.WaitGroup
 	for _, o := range a.outputs {
+		o.add(points...)
 		wg.Add(1)
-		go a.writeOutput(points, o, shutdown, &wg)
+		go func(o *runningOutput) {
+			defer wg.Done()
+			if err := o.write(shutdown); err != nil {
+				log.Printf("Error in output [%s]: %s, %d metrics buffered\n",
+					o.name, err, o.buffered())
+			}
+		}(o)
+	}
+	wg.Wait()
+}
+
+// flushOutput writes the points buffered for the output on its own flush
+// interval, so that a slow or failing output does not delay the others.
+// After a failed flush the output waits twice as long as after the previous
+// one, up to its flush_backoff_max, before trying again.
+func (a *Agent) flushOutput(o *runningOutput, stop chan struct{}) {
+	interval := o.config.FlushInterval.Duration
+	if interval == 0 {
+		interval = a.FlushInterval.Duration
+	} else {
+		interval = jitterInterval(interval, a.FlushJitter.Duration)
+	}
+	backoffMax := o.config.FlushBackoffMax.Duration
+	if backoffMax == 0 {
+		backoffMax = defaultFlushBackoffMax
 	}
-	if wait {
-		wg.Wait()
+	maxSkip := int(backoffMax/interval) - 1
+	if maxSkip < 0 {
+		maxSkip = 0
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := o.write(stop); err != nil {
+				log.Printf("Error in output [%s]: %s, dropping %d metrics "+
+					"at shutdown\n", o.name, err, o.buffer.Len())
+			}
+			return
+		case <-ticker.C:
+			if o.skip > 0 {
+				o.skip--
+				continue
+			}
+			if err := o.write(stop); err != nil {
+				skip := o.flushFailed(maxSkip)
+				log.Printf("Error in output [%s]: %s, %d metrics buffered, "+
+					"retrying in %s\n", o.name, err, o.buffered(),
+					time.Duration(skip+1)*interval)
+				continue
+			}
+			o.flushSucceeded()
+		}
 	}
 }
 
-// flusher monitors the points input channel and flushes on the minimum interval
-func (a *Agent) flusher(shutdown chan struct{}, pointChan chan *client.Point) error {
-	// Inelegant, but this sleep is to allow the Gather threads to run, so that
-	// the flusher will flush after metrics are collected.
-	time.Sleep(time.Millisecond * 100)
+// flusher hands the points gathered to the buffer of every output, which
+// each write them on their own flush loop.
+func (a *Agent) flusher(shutdown chan struct{}, pointChan *pointChannel) error {
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, o := range a.outputs {
+		wg.Add(1)
+		go func(o *runningOutput) {
+			defer wg.Done()
+			a.flushOutput(o, stop)
+		}(o)
+	}
 
 	ticker := time.NewTicker(a.FlushInterval.Duration)
-	points := make([]*client.Point, 0)
-
+	defer ticker.Stop()
+	var last pointChannelStats
+	gathered := selfstat.Register("agent", "metrics_gathered", nil)
+	dropped := selfstat.Register("agent", "metrics_dropped", nil)
+	blocked := selfstat.Register("agent", "gather_blocked", nil)
+	queued := selfstat.Register("agent", "point_buffer_size", nil)
+	selfstat.Register("agent", "point_buffer_limit", nil).
+		Set(int64(cap(pointChan.points)))
 	for {
 		select {
 		case <-shutdown:
 			log.Println("Hang on, flushing any cached points before shutdown")
-			a.flush(points, shutdown, true)
+			// Hand over the points already gathered before the outputs
+			// write for the last time
+		drain:
+			for {
+				select {
+				case pt := <-pointChan.points:
+					a.addToOutputs(receiveWaiting(pt, pointChan.points)...)
+				default:
+					break drain
+				}
+			}
+			close(stop)
+			wg.Wait()
 			return nil
 		case <-ticker.C:
-			a.flush(points, shutdown, false)
-			points = make([]*client.Point, 0)
-		case pt := <-pointChan:
-			points = append(points, pt)
+			a.addToOutputs(a.bufferStats()...)
+
+			stats := pointChan.stats()
+			if stats.dropped > last.dropped || stats.blocked > last.blocked {
+				log.Printf("Point buffer full: dropped %d points, plugins "+
+					"waited %d times (%s policy, %d points queued)\n",
+					stats.dropped-last.dropped, stats.blocked-last.blocked,
+					pointChan.policy, stats.queued)
+			}
+			last = stats
+
+			gathered.Set(stats.sent)
+			dropped.Set(stats.dropped)
+			blocked.Set(stats.blocked)
+			queued.Set(stats.queued)
+		case pt := <-pointChan.points:
+			a.addToOutputs(receiveWaiting(pt, pointChan.points)...)
 		}
 	}
 }
 
+// receiveWaiting returns first along with the points already waiting in
+// points, so that they are added to the outputs together.
+func receiveWaiting(
+	first *client.Point,
+	points chan *client.Point,
+) []*client.Point {
+	batch := []*client.Point{first}
+	for n := len(points); n > 0; n-- {
+		select {
+		case pt, ok := <-points:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, pt)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// addToOutputs adds points to the buffer of every output.
+func (a *Agent) addToOutputs(points ...*client.Point) {
+	for _, o := range a.outputs {
+		o.add(points...)
+	}
+}
+
 // jitterInterval applies the the interval jitter to the flush interval using
 // crypto/rand number generator
 func jitterInterval(ininterval, injitter time.Duration) time.Duration {
@@ -433,7 +723,11 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 		a.Interval, a.Debug, a.Hostname, a.FlushInterval)
 
 	// channel shared between all plugin threads for accumulating points
-	pointChan := make(chan *client.Point, 1000)
+	pointChan, err := newPointChannel(a.PointBufferSize, a.PointBufferPolicy)
+	if err != nil {
+		return err
+	}
+	a.pointChan = pointChan
 
 	// Round collection to nearest interval by sleeping
 	if a.RoundInterval {
@@ -1,11 +1,21 @@
 package telegraf
 
 import (
-	"github.com/stretchr/testify/assert"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/influxdb/telegraf/internal"
+	"github.com/influxdb/telegraf/plugins"
+
+	"github.com/influxdb/influxdb/client/v2"
 
 	// needing to load the plugins
 	_ "github.com/influxdb/telegraf/plugins/all"
@@ -35,6 +45,18 @@ func TestAgent_LoadPlugin(t *testing.T) {
 	assert.Equal(t, 2, len(pluginsEnabled))
 }
 
+func TestAgent_LoadPluginInstances(t *testing.T) {
+	config, _ := LoadConfig("./testdata/plugin_instances.toml")
+	a, _ := NewAgent(config)
+
+	pluginsEnabled, err := a.LoadPlugins([]string{"memcached"}, config)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"memcached-0", "memcached-1"}, pluginsEnabled)
+	for _, plugin := range a.plugins {
+		assert.Equal(t, "memcached", plugin.config.Name)
+	}
+}
+
 func TestAgent_LoadOutput(t *testing.T) {
 	// load a dedicated configuration file
 	config, _ := LoadConfig("./testdata/telegraf-agent.toml")
@@ -152,3 +174,372 @@ func TestAgent_JitterMin(t *testing.T) {
 		}
 	}
 }
+
+// backfillPlugin adds one point per minute of the backfilled range.
+type backfillPlugin struct {
+	plugins.Plugin
+}
+
+func (p *backfillPlugin) Backfill(
+	acc plugins.Accumulator,
+	start, end time.Time,
+) error {
+	for t := start; t.Before(end); t = t.Add(time.Minute) {
+		acc.Add("value", 1, nil, t)
+	}
+	return nil
+}
+
+// recordingOutput keeps the batches written to it, or fails with err if set.
+type recordingOutput struct {
+	sync.Mutex
+	batches [][]*client.Point
+	err     error
+}
+
+// written returns the number of points written.
+func (o *recordingOutput) written() int {
+	o.Lock()
+	defer o.Unlock()
+	n := 0
+	for _, batch := range o.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+func (o *recordingOutput) Connect() error       { return nil }
+func (o *recordingOutput) Close() error         { return nil }
+func (o *recordingOutput) Description() string  { return "" }
+func (o *recordingOutput) SampleConfig() string { return "" }
+
+func (o *recordingOutput) Write(points []*client.Point) error {
+	o.Lock()
+	defer o.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	o.batches = append(o.batches, points)
+	return nil
+}
+
+func testPoint(t *testing.T, value int) *client.Point {
+	pt, err := client.NewPoint("cpu", map[string]string{"host": "localhost"},
+		map[string]interface{}{"value": int64(value)},
+		time.Unix(int64(value), 0))
+	require.NoError(t, err)
+	return pt
+}
+
+func testOutput(
+	t *testing.T,
+	output *recordingOutput,
+	config *ConfiguredOutput,
+) *runningOutput {
+	ro, err := newRunningOutput("recording", output, config)
+	require.NoError(t, err)
+	return ro
+}
+
+func TestAgent_OutputBatches(t *testing.T) {
+	output := &recordingOutput{}
+	a := &Agent{outputs: []*runningOutput{
+		testOutput(t, output, &ConfiguredOutput{MetricBatchSize: 3}),
+	}}
+
+	var points []*client.Point
+	for i := 0; i < 7; i++ {
+		points = append(points, testPoint(t, i))
+	}
+	a.flush(points)
+
+	require.Len(t, output.batches, 3)
+	assert.Len(t, output.batches[0], 3)
+	assert.Len(t, output.batches[1], 3)
+	assert.Len(t, output.batches[2], 1)
+	assert.Equal(t, 0, a.outputs[0].buffer.Len())
+}
+
+func TestAgent_OutputBuffers(t *testing.T) {
+	working := &recordingOutput{}
+	failing := &recordingOutput{err: errors.New("connection refused")}
+	a := &Agent{outputs: []*runningOutput{
+		testOutput(t, working, nil),
+		testOutput(t, failing, &ConfiguredOutput{MetricBufferLimit: 3}),
+	}}
+
+	// A failing output keeps its newest points, without holding back the
+	// others
+	a.flush([]*client.Point{testPoint(t, 1), testPoint(t, 2)})
+	a.flush([]*client.Point{testPoint(t, 3), testPoint(t, 4)})
+	assert.Len(t, working.batches, 2)
+	assert.Equal(t, 3, a.outputs[1].buffer.Len())
+	assert.Equal(t, int64(1), a.outputs[1].buffer.Dropped())
+
+	failing.err = nil
+	a.flush(nil)
+	require.Len(t, failing.batches, 1)
+	require.Len(t, failing.batches[0], 3)
+	assert.Equal(t, "cpu,host=localhost value=2i 2000000000",
+		failing.batches[0][0].String())
+	assert.Len(t, working.batches, 2)
+}
+
+// blockingOutput blocks every write until release is closed.
+type blockingOutput struct {
+	recordingOutput
+	release chan struct{}
+}
+
+func (o *blockingOutput) Write(points []*client.Point) error {
+	<-o.release
+	return nil
+}
+
+func TestAgent_FlusherIndependentOutputs(t *testing.T) {
+	working := &recordingOutput{}
+	blocked := &blockingOutput{release: make(chan struct{})}
+	slow, err := newRunningOutput("blocked", blocked, nil)
+	require.NoError(t, err)
+
+	a := &Agent{
+		FlushInterval: internal.Duration{10 * time.Millisecond},
+		outputs:       []*runningOutput{testOutput(t, working, nil), slow},
+	}
+	shutdown := make(chan struct{})
+	pointChan, err := newPointChannel(0, policyBlock)
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.flusher(shutdown, pointChan)
+	}()
+
+	pointChan.send(testPoint(t, 1))
+	deadline := time.Now().Add(5 * time.Second)
+	for working.written() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, 1, working.written())
+
+	close(blocked.release)
+	close(shutdown)
+	<-done
+}
+
+func TestAgent_ReceiveWaiting(t *testing.T) {
+	points := make(chan *client.Point, 10)
+	for i := 2; i <= 4; i++ {
+		points <- testPoint(t, i)
+	}
+
+	batch := receiveWaiting(testPoint(t, 1), points)
+	require.Len(t, batch, 4)
+	for i, pt := range batch {
+		assert.Equal(t, int64(i+1), pt.Fields()["value"])
+	}
+	assert.Len(t, points, 0)
+
+	close(points)
+	assert.Len(t, receiveWaiting(testPoint(t, 1), points), 1)
+}
+
+func TestAgent_OutputBackoff(t *testing.T) {
+	ro := testOutput(t, &recordingOutput{}, nil)
+
+	var skips []int
+	for i := 0; i < 6; i++ {
+		skips = append(skips, ro.flushFailed(10))
+	}
+	assert.Equal(t, []int{0, 1, 3, 7, 10, 10}, skips)
+
+	ro.flushSucceeded()
+	assert.Equal(t, 0, ro.flushFailed(10))
+}
+
+// failingPlugin fails every gather.
+type failingPlugin struct {
+	plugins.Plugin
+}
+
+func (p *failingPlugin) Gather(acc plugins.Accumulator) error {
+	return errors.New("unreachable")
+}
+
+func TestAgent_SelfStats(t *testing.T) {
+	rp := newRunningPlugin("failing-1", &failingPlugin{},
+		&ConfiguredPlugin{Name: "failing"})
+	rp.gather(nil)
+	rp.gather(nil)
+	assert.Equal(t, int64(2), rp.gatherErrors.Get())
+
+	output := &recordingOutput{err: errors.New("unavailable")}
+	ro, err := newRunningOutput("selfstats", output,
+		&ConfiguredOutput{Name: "selfstats", MetricBufferLimit: 2})
+	require.NoError(t, err)
+	ro.add(testPoint(t, 1), testPoint(t, 2), testPoint(t, 3))
+	assert.Error(t, ro.write(nil))
+	assert.Equal(t, int64(1), ro.writeErrors.Get())
+	assert.Equal(t, int64(2), ro.bufferSize.Get())
+	assert.Equal(t, int64(1), ro.metricsDropped.Get())
+
+	output.err = nil
+	require.NoError(t, ro.write(nil))
+	assert.Equal(t, int64(2), ro.metricsWritten.Get())
+	assert.Equal(t, int64(2), ro.batchWritten.Get())
+	assert.Equal(t, int64(0), ro.bufferSize.Get())
+	assert.Equal(t, int64(2), ro.bufferLimit.Get())
+}
+
+// hangingPlugin blocks in Gather until release is closed, then adds a point.
+type hangingPlugin struct {
+	plugins.Plugin
+	release chan struct{}
+}
+
+func (p *hangingPlugin) Gather(acc plugins.Accumulator) error {
+	<-p.release
+	acc.Add("hanging", 1, nil)
+	return nil
+}
+
+func TestAgent_GatherTimeout(t *testing.T) {
+	hanging := &hangingPlugin{release: make(chan struct{})}
+	rp := newRunningPlugin("hanging-1", hanging,
+		&ConfiguredPlugin{Name: "hanging", Timeout: 10 * time.Millisecond})
+	released := make(chan struct{})
+	close(released)
+	fast := newRunningPlugin("fast-1", &hangingPlugin{release: released},
+		&ConfiguredPlugin{Name: "fast"})
+
+	pointChan, err := newPointChannel(10, policyBlock)
+	require.NoError(t, err)
+	a := &Agent{
+		Interval: internal.Duration{time.Hour},
+		plugins:  []*runningPlugin{rp, fast},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.gatherParallel(pointChan)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("gather waited for the hanging plugin")
+	}
+	assert.Equal(t, int64(1), rp.gatherTimeouts.Get())
+	assert.Equal(t, int64(0), fast.gatherTimeouts.Get())
+	assert.Equal(t, int64(1), pointChan.stats().sent)
+
+	// The next gather is skipped while the previous one is still running
+	rp.gather(nil)
+	assert.Equal(t, int64(1), rp.gatherSkipped.Get())
+
+	close(hanging.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&rp.running) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// The point added after the timeout is still passed on, counted as late
+	assert.Equal(t, int64(2), pointChan.stats().sent)
+	assert.Equal(t, int64(1), rp.gatherLate.Get())
+
+	acc := NewAccumulator(&ConfiguredPlugin{Name: "hanging"}, pointChan)
+	rp.gather(acc)
+	assert.Equal(t, int64(1), rp.gatherSkipped.Get())
+	assert.Equal(t, int64(3), pointChan.stats().sent)
+	assert.Equal(t, int64(1), rp.gatherLate.Get())
+}
+
+func TestAgent_DiskBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telegraf-agent")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	newOutput := func(output *recordingOutput) *runningOutput {
+		return testOutput(t, output, &ConfiguredOutput{DiskBufferPath: dir})
+	}
+
+	output := &recordingOutput{err: errors.New("connection refused")}
+	a := &Agent{outputs: []*runningOutput{newOutput(output)}}
+
+	// Batches failing to write are kept on disk
+	a.flush([]*client.Point{testPoint(t, 1), testPoint(t, 2)})
+	a.flush([]*client.Point{testPoint(t, 3)})
+	assert.Empty(t, output.batches)
+	assert.Equal(t, int64(3), a.outputs[0].queue.Stats().Points)
+
+	stats := a.bufferStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "telegraf_disk_buffer", stats[0].Name())
+	assert.Equal(t, "recording", stats[0].Tags()["output"])
+	assert.Equal(t, int64(2), stats[0].Fields()["batches"])
+
+	// and written in order once the output is back, even after a restart
+	output = &recordingOutput{}
+	a = &Agent{outputs: []*runningOutput{newOutput(output)}}
+	a.flush([]*client.Point{testPoint(t, 4)})
+
+	require.Len(t, output.batches, 3)
+	assert.Len(t, output.batches[0], 2)
+	assert.Equal(t, "cpu,host=localhost value=1i 1000000000", output.batches[0][0].String())
+	assert.Equal(t, "cpu,host=localhost value=3i 3000000000", output.batches[1][0].String())
+	assert.Equal(t, "cpu,host=localhost value=4i 4000000000", output.batches[2][0].String())
+	assert.Equal(t, 0, a.outputs[0].queue.Len())
+}
+
+func TestAgent_Backfill(t *testing.T) {
+	output := &recordingOutput{}
+	a := &Agent{
+		Tags:    map[string]string{"host": "localhost"},
+		outputs: []*runningOutput{testOutput(t, output, nil)},
+		plugins: []*runningPlugin{
+			newRunningPlugin("backfill", &backfillPlugin{},
+				&ConfiguredPlugin{Name: "backfill"}),
+		},
+	}
+
+	start := time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+	require.NoError(t, a.Backfill("backfill", start, end))
+
+	require.Len(t, output.batches, 2)
+	assert.Len(t, output.batches[0], backfillBatchSize)
+	assert.Len(t, output.batches[1], 1440-backfillBatchSize)
+	assert.Equal(t, "backfill_value", output.batches[0][0].Name())
+	assert.Equal(t, start, output.batches[0][0].Time())
+	assert.Equal(t, "localhost", output.batches[0][0].Tags()["host"])
+
+	assert.Error(t, a.Backfill("other", start, end))
+}
+
+func TestAgent_BackfillOutputFailure(t *testing.T) {
+	output := &recordingOutput{err: errors.New("unavailable")}
+	a := &Agent{
+		outputs: []*runningOutput{testOutput(t, output, nil)},
+		plugins: []*runningPlugin{
+			newRunningPlugin("backfill", &backfillPlugin{},
+				&ConfiguredPlugin{Name: "backfill"}),
+		},
+	}
+
+	start := time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+	err := a.Backfill("backfill", start, start.Add(time.Hour))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "60 metrics unwritten")
+}
+
+func TestAgent_BackfillUnsupported(t *testing.T) {
+	a := &Agent{
+		plugins: []*runningPlugin{
+			newRunningPlugin("mock", &struct{ plugins.Plugin }{},
+				&ConfiguredPlugin{Name: "mock"}),
+		},
+	}
+	err := a.Backfill("mock", time.Now(), time.Now().Add(time.Hour))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not support backfilling")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/influxdb/telegraf"
 	_ "github.com/influxdb/telegraf/outputs/all"
@@ -29,6 +30,9 @@ var fOutputFilters = flag.String("outputfilter", "",
 	"filter the outputs to enable, separator is :")
 var fUsage = flag.String("usage", "",
 	"print usage for a plugin, ie, 'telegraf -usage mysql'")
+var fCloudWatchBackfill = flag.String("cloudwatch-backfill", "",
+	"gather the cloudwatch plugin over a time range, write it to the outputs"+
+		" and exit, ie, '2015-11-01T00:00Z/2015-11-02T00:00Z'")
 
 // Telegraf version
 //	-ldflags "-X main.Version=`git describe --always --tags`"
@@ -132,6 +136,20 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *fCloudWatchBackfill != "" {
+		start, end, err := parseTimeRange(*fCloudWatchBackfill)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Backfilling cloudwatch from %s to %s", start, end)
+		err = ag.Backfill("cloudwatch", start, end)
+		ag.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	shutdown := make(chan struct{})
 	signals := make(chan os.Signal)
 	signal.Notify(signals, os.Interrupt)
@@ -158,3 +176,42 @@ func main() {
 
 	ag.Run(shutdown)
 }
+
+// timeLayouts are the accepted formats of the times of a range, from the
+// most to the least precise.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// parseTimeRange parses a range of two times separated by a slash, ie,
+// 2015-11-01T00:00Z/2015-11-02T00:00Z.
+func parseTimeRange(s string) (time.Time, time.Time, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return time.Time{}, time.Time{},
+			fmt.Errorf("time range %q is not of the form start/end", s)
+	}
+
+	var times [2]time.Time
+	for i, part := range parts {
+		var err error
+		for _, layout := range timeLayouts {
+			times[i], err = time.Parse(layout, part)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return time.Time{}, time.Time{},
+				fmt.Errorf("invalid time %q in range %q", part, s)
+		}
+	}
+
+	if !times[0].Before(times[1]) {
+		return time.Time{}, time.Time{},
+			fmt.Errorf("time range %q does not start before it ends", s)
+	}
+	return times[0], times[1], nil
+}
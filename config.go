@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/influxdb/telegraf/internal"
 	"github.com/influxdb/telegraf/outputs"
 	"github.com/influxdb/telegraf/plugins"
 	"github.com/naoina/toml"
@@ -25,18 +26,27 @@ type Config struct {
 	// maps normally. We just copy the elements manually in ApplyAgent.
 	Tags map[string]string
 
-	agent                *Agent
+	agent *Agent
+	// plugins and pluginConfigurations are keyed by instance ID: the plugin
+	// name for a [name] table and name-N for the Nth [[name]] table.
 	plugins              map[string]plugins.Plugin
 	pluginConfigurations map[string]*ConfiguredPlugin
 	outputs              map[string]outputs.Output
+	outputConfigurations map[string]*ConfiguredOutput
+
+	// pluginInstances lists the IDs of the [[name]] tables of each plugin,
+	// in the order they were declared.
+	pluginInstances map[string][]string
 
 	agentFieldsSet               []string
 	pluginFieldsSet              map[string][]string
 	pluginConfigurationFieldsSet map[string][]string
 	outputFieldsSet              map[string][]string
+	outputConfigurationFieldsSet map[string][]string
 }
 
-// Plugins returns the configured plugins as a map of name -> plugins.Plugin
+// Plugins returns the configured plugins as a map of instance ID ->
+// plugins.Plugin
 func (c *Config) Plugins() map[string]plugins.Plugin {
 	return c.plugins
 }
@@ -52,9 +62,10 @@ type TagFilter struct {
 	Filter []string
 }
 
-// ConfiguredPlugin containing a name, interval, and drop/pass prefix lists
+// ConfiguredPlugin containing a name, interval, timeout and drop/pass prefix lists
 // Also lists the tags to filter
 type ConfiguredPlugin struct {
+	// Name is the name of the plugin, shared by all of its instances
 	Name string
 
 	Drop []string
@@ -64,6 +75,10 @@ type ConfiguredPlugin struct {
 	TagPass []TagFilter
 
 	Interval time.Duration
+
+	// Timeout is how long the agent waits for a gather before carrying on
+	// without it, no timeout if zero.
+	Timeout time.Duration
 }
 
 // ShouldPass returns true if the metric should pass, false if should drop
@@ -117,6 +132,51 @@ func (cp *ConfiguredPlugin) ShouldPass(measurement string, tags map[string]strin
 	return true
 }
 
+// ConfiguredOutput holds the settings the agent applies to an output,
+// whatever its kind.
+type ConfiguredOutput struct {
+	Name string
+
+	// MetricBufferLimit is the number of points held in memory for the
+	// output. Past it the oldest points are dropped.
+	MetricBufferLimit int
+	// MetricBatchSize is the largest number of points written at once.
+	MetricBatchSize int
+
+	// FlushInterval overrides the flush interval of the agent.
+	FlushInterval internal.Duration
+	// FlushBackoffMax is the longest time writes wait after failures.
+	// Every failed flush doubles the wait, starting from FlushInterval.
+	FlushBackoffMax internal.Duration
+
+	// DiskBufferPath is the directory in which the points the output has not
+	// accepted yet are kept, to survive outages and restarts. Points are
+	// only held in memory if it is not set.
+	DiskBufferPath string
+	// DiskBufferMaxMB is the size the disk buffer may grow to before its
+	// oldest points are evicted.
+	DiskBufferMaxMB int64 `toml:"disk_buffer_max_mb"`
+}
+
+// Defaults of the output settings.
+const (
+	defaultMetricBufferLimit = 10000
+	defaultMetricBatchSize   = 1000
+	defaultFlushBackoffMax   = 5 * time.Minute
+	defaultDiskBufferMaxMB   = 100
+)
+
+// outputConfigurationFields are the settings of an output table parsed into
+// ConfiguredOutput instead of the output itself.
+var outputConfigurationFields = []string{
+	"metric_buffer_limit",
+	"metric_batch_size",
+	"flush_interval",
+	"flush_backoff_max",
+	"disk_buffer_path",
+	"disk_buffer_max_mb",
+}
+
 // ApplyOutput loads the Output struct built from the config into the given Output struct.
 // Overrides only values in the given struct that were set in the config.
 func (c *Config) ApplyOutput(name string, v interface{}) error {
@@ -139,13 +199,20 @@ func (c *Config) ApplyAgent(a *Agent) error {
 	return nil
 }
 
-func (c *Config) GetPluginConfig(name string) *ConfiguredPlugin {
-	return c.pluginConfigurations[name]
+// GetPluginConfig returns the meta-config of the plugin instance id.
+func (c *Config) GetPluginConfig(id string) *ConfiguredPlugin {
+	return c.pluginConfigurations[id]
+}
+
+// GetOutputConfig returns the meta-config of the output id.
+func (c *Config) GetOutputConfig(id string) *ConfiguredOutput {
+	return c.outputConfigurations[id]
 }
 
 // Couldn't figure out how to get this to work with the declared function.
 
-// PluginsDeclared returns the name of all plugins declared in the config.
+// PluginsDeclared returns all plugin instances declared in the config, keyed
+// by instance ID.
 func (c *Config) PluginsDeclared() map[string]plugins.Plugin {
 	return c.plugins
 }
@@ -427,7 +494,12 @@ func (c *Config) LoadDirectory(path string) error {
 				}
 			}
 		}
+		// A [name] table is merged into the [name] table already loaded, if
+		// any. [[name]] tables are always added as new instances.
 		for pluginName, plugin := range subConfig.plugins {
+			if subConfig.pluginConfigurations[pluginName].Name != pluginName {
+				continue
+			}
 			if _, ok := c.plugins[pluginName]; !ok {
 				c.plugins[pluginName] = plugin
 				c.pluginFieldsSet[pluginName] = subConfig.pluginFieldsSet[pluginName]
@@ -454,12 +526,23 @@ func (c *Config) LoadDirectory(path string) error {
 				}
 			}
 		}
+		for _, name := range subConfig.pluginInstanceNames() {
+			for _, id := range subConfig.pluginInstances[name] {
+				c.addPluginInstance(name, subConfig, id)
+			}
+		}
 		for outputName, output := range subConfig.outputs {
 			if _, ok := c.outputs[outputName]; !ok {
 				c.outputs[outputName] = output
 				c.outputFieldsSet[outputName] = subConfig.outputFieldsSet[outputName]
+				c.outputConfigurations[outputName] = subConfig.outputConfigurations[outputName]
+				c.outputConfigurationFieldsSet[outputName] = subConfig.outputConfigurationFieldsSet[outputName]
 				continue
 			}
+			err = mergeStruct(c.outputConfigurations[outputName], subConfig.outputConfigurations[outputName], subConfig.outputConfigurationFieldsSet[outputName])
+			if err != nil {
+				return err
+			}
 			err = mergeStruct(c.outputs[outputName], output, subConfig.outputFieldsSet[outputName])
 			if err != nil {
 				return err
@@ -474,6 +557,33 @@ func (c *Config) LoadDirectory(path string) error {
 	return nil
 }
 
+// pluginInstanceNames returns the names of the plugins declared with [[name]]
+// tables, sorted.
+func (c *Config) pluginInstanceNames() []string {
+	var names []string
+	for name := range c.pluginInstances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addPluginInstance adds the instance id of the plugin name loaded in other
+// as the next instance of the plugin.
+func (c *Config) addPluginInstance(name string, other *Config, id string) {
+	newID := pluginInstanceID(name, len(c.pluginInstances[name]))
+	c.plugins[newID] = other.plugins[id]
+	c.pluginFieldsSet[newID] = other.pluginFieldsSet[id]
+	c.pluginConfigurations[newID] = other.pluginConfigurations[id]
+	c.pluginConfigurationFieldsSet[newID] = other.pluginConfigurationFieldsSet[id]
+	c.pluginInstances[name] = append(c.pluginInstances[name], newID)
+}
+
+// pluginInstanceID returns the ID of the index-th [[name]] table.
+func pluginInstanceID(name string, index int) string {
+	return fmt.Sprintf("%s-%d", name, index)
+}
+
 // hazmat area. Keeping the ast parsing here.
 
 // LoadConfig loads the given config file and returns a *Config pointer
@@ -493,12 +603,33 @@ func LoadConfig(path string) (*Config, error) {
 		plugins:                      make(map[string]plugins.Plugin),
 		pluginConfigurations:         make(map[string]*ConfiguredPlugin),
 		outputs:                      make(map[string]outputs.Output),
+		outputConfigurations:         make(map[string]*ConfiguredOutput),
+		pluginInstances:              make(map[string][]string),
 		pluginFieldsSet:              make(map[string][]string),
 		pluginConfigurationFieldsSet: make(map[string][]string),
 		outputFieldsSet:              make(map[string][]string),
+		outputConfigurationFieldsSet: make(map[string][]string),
 	}
 
 	for name, val := range tbl.Fields {
+		// Plugins may be declared several times, e.g. to gather from the
+		// same service with different intervals or filters
+		if pluginTables, ok := val.([]*ast.Table); ok {
+			switch name {
+			case "agent", "tags", "outputs":
+				return nil, fmt.Errorf("Unsupported config format: [[%s]]",
+					name)
+			}
+			for i, t := range pluginTables {
+				id := pluginInstanceID(name, i)
+				if err = c.parsePlugin(name, t, id); err != nil {
+					return nil, err
+				}
+				c.pluginInstances[name] = append(c.pluginInstances[name], id)
+			}
+			continue
+		}
+
 		subTable, ok := val.(*ast.Table)
 		if !ok {
 			return nil, errors.New("invalid configuration")
@@ -541,7 +672,7 @@ func LoadConfig(path string) (*Config, error) {
 				}
 			}
 		default:
-			err = c.parsePlugin(name, subTable)
+			err = c.parsePlugin(name, subTable, name)
 			if err != nil {
 				return nil, err
 			}
@@ -573,24 +704,47 @@ func (c *Config) parseAgent(agentAst *ast.Table) error {
 	return nil
 }
 
-// Parse an output config out of the given *ast.Table.
+// Parse an output config, plus output meta-config, out of the given
+// *ast.Table.
 func (c *Config) parseOutput(name string, outputAst *ast.Table, id int) error {
-	c.outputFieldsSet[name] = extractFieldNames(outputAst)
 	creator, ok := outputs.Outputs[name]
 	if !ok {
 		return fmt.Errorf("Undefined but requested output: %s", name)
 	}
 	output := creator()
+	outputID := fmt.Sprintf("%s-%d", name, id)
+
+	co := &ConfiguredOutput{Name: name}
+	coAst := &ast.Table{Fields: make(map[string]interface{})}
+	for _, field := range outputConfigurationFields {
+		if node, ok := outputAst.Fields[field]; ok {
+			coAst.Fields[field] = node
+			delete(outputAst.Fields, field)
+		}
+	}
+	if err := toml.UnmarshalTable(coAst, co); err != nil {
+		return err
+	}
+	if co.MetricBufferLimit < 0 || co.MetricBatchSize < 0 ||
+		co.DiskBufferMaxMB < 0 {
+		return fmt.Errorf("buffer and batch sizes must not be negative in "+
+			"output %s", name)
+	}
+
+	c.outputFieldsSet[name] = extractFieldNames(outputAst)
+	c.outputConfigurationFieldsSet[outputID] = extractFieldNames(coAst)
 	err := toml.UnmarshalTable(outputAst, output)
 	if err != nil {
 		return err
 	}
-	c.outputs[fmt.Sprintf("%s-%d", name, id)] = output
+	c.outputs[outputID] = output
+	c.outputConfigurations[outputID] = co
 	return nil
 }
 
-// Parse a plugin config, plus plugin meta-config, out of the given *ast.Table.
-func (c *Config) parsePlugin(name string, pluginAst *ast.Table) error {
+// Parse a plugin config, plus plugin meta-config, out of the given *ast.Table
+// and store it as the instance id.
+func (c *Config) parsePlugin(name string, pluginAst *ast.Table, id string) error {
 	creator, ok := plugins.Plugins[name]
 	if !ok {
 		return fmt.Errorf("Undefined but requested plugin: %s", name)
@@ -639,6 +793,23 @@ func (c *Config) parsePlugin(name string, pluginAst *ast.Table) error {
 		}
 	}
 
+	// Only a duration is the gather timeout, plugins such as ping have a
+	// numeric timeout option of their own
+	if node, ok := pluginAst.Fields["timeout"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return err
+				}
+
+				cp.Timeout = dur
+				cpFields = append(cpFields, "timeout")
+				delete(pluginAst.Fields, "timeout")
+			}
+		}
+	}
+
 	if node, ok := pluginAst.Fields["tagpass"]; ok {
 		if subtbl, ok := node.(*ast.Table); ok {
 			for name, val := range subtbl.Fields {
@@ -682,13 +853,13 @@ func (c *Config) parsePlugin(name string, pluginAst *ast.Table) error {
 	delete(pluginAst.Fields, "interval")
 	delete(pluginAst.Fields, "tagdrop")
 	delete(pluginAst.Fields, "tagpass")
-	c.pluginFieldsSet[name] = extractFieldNames(pluginAst)
-	c.pluginConfigurationFieldsSet[name] = cpFields
+	c.pluginFieldsSet[id] = extractFieldNames(pluginAst)
+	c.pluginConfigurationFieldsSet[id] = cpFields
 	err := toml.UnmarshalTable(pluginAst, plugin)
 	if err != nil {
 		return err
 	}
-	c.plugins[name] = plugin
-	c.pluginConfigurations[name] = cp
+	c.plugins[id] = plugin
+	c.pluginConfigurations[id] = cp
 	return nil
 }
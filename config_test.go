@@ -6,9 +6,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdb/telegraf/internal"
+	"github.com/influxdb/telegraf/outputs"
+	"github.com/influxdb/telegraf/outputs/influxdb"
 	"github.com/influxdb/telegraf/plugins"
 	"github.com/influxdb/telegraf/plugins/exec"
 	"github.com/influxdb/telegraf/plugins/memcached"
+	"github.com/influxdb/telegraf/plugins/ping"
 	"github.com/influxdb/telegraf/plugins/procstat"
 	"github.com/naoina/toml"
 	"github.com/naoina/toml/ast"
@@ -206,7 +210,7 @@ func TestConfig_parsePlugin(t *testing.T) {
 	}
 
 	subtbl := tbl.Fields["memcached"].(*ast.Table)
-	err = c.parsePlugin("memcached", subtbl)
+	err = c.parsePlugin("memcached", subtbl, "memcached")
 
 	memcached := plugins.Plugins["memcached"]().(*memcached.Memcached)
 	memcached.Servers = []string{"localhost"}
@@ -305,3 +309,99 @@ func TestConfig_LoadDirectory(t *testing.T) {
 	assert.Equal(t, pConfig, c.pluginConfigurations["procstat"],
 		"Merged Testdata did not produce correct procstat metadata.")
 }
+
+func TestConfig_PluginInstances(t *testing.T) {
+	c, err := LoadConfig("./testdata/plugin_instances.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := plugins.Plugins["memcached"]().(*memcached.Memcached)
+	first.Servers = []string{"10.0.0.1"}
+	second := plugins.Plugins["memcached"]().(*memcached.Memcached)
+	second.Servers = []string{"10.0.0.2"}
+
+	assert.Len(t, c.plugins, 3)
+	assert.Equal(t, first, c.plugins["memcached-0"])
+	assert.Equal(t, &ConfiguredPlugin{Name: "memcached", Interval: 5 * time.Second},
+		c.pluginConfigurations["memcached-0"])
+	assert.Equal(t, second, c.plugins["memcached-1"])
+	assert.Equal(t, &ConfiguredPlugin{
+		Name:     "memcached",
+		Pass:     []string{"memcached_get"},
+		Interval: time.Minute,
+	}, c.pluginConfigurations["memcached-1"])
+	assert.Equal(t, &ConfiguredPlugin{Name: "procstat"},
+		c.pluginConfigurations["procstat"])
+}
+
+func TestConfig_LoadDirectoryPluginInstances(t *testing.T) {
+	c, err := LoadConfig("./testdata/plugin_instances.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoadDirectory("./testdata/instances"); err != nil {
+		t.Fatal(err)
+	}
+
+	// [[memcached]] tables are added as new instances
+	third := plugins.Plugins["memcached"]().(*memcached.Memcached)
+	third.Servers = []string{"10.0.0.3"}
+
+	assert.Len(t, c.plugins, 4)
+	assert.Equal(t, third, c.plugins["memcached-2"])
+	assert.Equal(t, &ConfiguredPlugin{Name: "memcached"},
+		c.pluginConfigurations["memcached-2"])
+	assert.Equal(t, []string{"memcached-0", "memcached-1", "memcached-2"},
+		c.pluginInstances["memcached"])
+
+	// while a [procstat] table is merged into the one already loaded
+	pstat := plugins.Plugins["procstat"]().(*procstat.Procstat)
+	pstat.Specifications = []*procstat.Specification{
+		&procstat.Specification{
+			PidFile: "/var/run/grafana-server.pid",
+		},
+	}
+	assert.Equal(t, pstat, c.plugins["procstat"])
+}
+
+func TestConfig_parseOutputConfig(t *testing.T) {
+	c, err := LoadConfig("./testdata/output_config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &ConfiguredOutput{
+		Name:            "influxdb",
+		DiskBufferPath:  "/var/lib/telegraf/buffer/influxdb",
+		DiskBufferMaxMB: 512,
+	}, c.GetOutputConfig("influxdb-0"))
+	assert.Equal(t, &ConfiguredOutput{
+		Name:              "influxdb",
+		MetricBufferLimit: 50000,
+		MetricBatchSize:   500,
+		FlushInterval:     internal.Duration{30 * time.Second},
+		FlushBackoffMax:   internal.Duration{10 * time.Minute},
+	}, c.GetOutputConfig("influxdb-1"))
+
+	influx := outputs.Outputs["influxdb"]().(*influxdb.InfluxDB)
+	influx.URLs = []string{"http://localhost:8086"}
+	influx.Database = "telegraf"
+	assert.Equal(t, influx, c.outputs["influxdb-0"])
+}
+
+func TestConfig_PluginTimeout(t *testing.T) {
+	c, err := LoadConfig("./testdata/plugin_timeout.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &ConfiguredPlugin{Name: "memcached", Timeout: 30 * time.Second},
+		c.pluginConfigurations["memcached"])
+	assert.Equal(t, []string{"servers"}, c.pluginFieldsSet["memcached"])
+
+	// A numeric timeout is an option of the plugin itself
+	assert.Equal(t, &ConfiguredPlugin{Name: "ping"},
+		c.pluginConfigurations["ping"])
+	assert.Equal(t, 2.0, c.plugins["ping"].(*ping.Ping).Timeout)
+}
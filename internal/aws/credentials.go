@@ -0,0 +1,204 @@
+// Package aws holds the AWS credentials and sessions shared by the plugins
+// and outputs talking to AWS.
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/private/protocol/query"
+	"github.com/aws/aws-sdk-go/private/signer/v4"
+)
+
+// CredentialConfig selects the credentials used to talk to AWS. When nothing
+// is set, the SDK default chain (environment, shared credentials file, EC2
+// instance role) is used.
+//
+// Sources are tried in this order: static keys, shared credentials profile,
+// EC2 instance role. If RoleArn is set, the selected credentials are used to
+// assume that role through STS.
+type CredentialConfig struct {
+	AccessKey string
+	SecretKey string
+	Token     string
+
+	Profile              string
+	SharedCredentialFile string
+
+	UseInstanceRole bool
+
+	RoleArn         string
+	ExternalId      string
+	RoleSessionName string
+}
+
+// Identity names the account or role the credentials stand for, without
+// any secret, or "" for the SDK default chain.
+func (c *CredentialConfig) Identity() string {
+	switch {
+	case c.RoleArn != "":
+		return "role:" + c.RoleArn + ":" + c.ExternalId
+	case c.AccessKey != "":
+		return "key:" + c.AccessKey
+	case c.Profile != "" || c.SharedCredentialFile != "":
+		return "profile:" + c.SharedCredentialFile + ":" + c.Profile
+	case c.UseInstanceRole:
+		return "instance-role"
+	}
+	return ""
+}
+
+// awsConfig builds the SDK configuration for the given region.
+func (c *CredentialConfig) awsConfig(region string) *aws.Config {
+	cfg := &aws.Config{Region: aws.String(region)}
+
+	switch {
+	case c.AccessKey != "" || c.SecretKey != "":
+		cfg.Credentials = credentials.NewStaticCredentials(
+			c.AccessKey, c.SecretKey, c.Token)
+	case c.Profile != "" || c.SharedCredentialFile != "":
+		cfg.Credentials = credentials.NewSharedCredentials(
+			c.SharedCredentialFile, c.Profile)
+	case c.UseInstanceRole:
+		cfg.Credentials = ec2rolecreds.NewCredentials(session.New())
+	}
+
+	if c.RoleArn != "" {
+		cfg.Credentials = credentials.NewCredentials(&assumeRoleProvider{
+			client:      newSTSClient(session.New(cfg)),
+			roleArn:     c.RoleArn,
+			sessionName: c.RoleSessionName,
+			externalId:  c.ExternalId,
+		})
+	}
+
+	return cfg
+}
+
+// The vendored SDK does not ship the STS service, which stscreds depends on,
+// so the single call needed to assume a role is declared here.
+
+const opAssumeRole = "AssumeRole"
+
+type assumeRoleInput struct {
+	DurationSeconds *int64  `min:"900" type:"integer"`
+	ExternalId      *string `min:"2" type:"string"`
+	RoleArn         *string `min:"20" type:"string" required:"true"`
+	RoleSessionName *string `min:"2" type:"string" required:"true"`
+}
+
+type stsCredentials struct {
+	AccessKeyId     *string    `type:"string" required:"true"`
+	Expiration      *time.Time `type:"timestamp" timestampFormat:"iso8601" required:"true"`
+	SecretAccessKey *string    `type:"string" required:"true"`
+	SessionToken    *string    `type:"string" required:"true"`
+}
+
+type assumeRoleOutput struct {
+	Credentials *stsCredentials `type:"structure"`
+}
+
+// stsAPI is the subset of STS used by assumeRoleProvider.
+type stsAPI interface {
+	AssumeRole(*assumeRoleInput) (*assumeRoleOutput, error)
+}
+
+type stsClient struct {
+	*client.Client
+}
+
+func newSTSClient(p client.ConfigProvider) *stsClient {
+	return &stsClient{NewQueryClient(p, "sts", "2011-06-15")}
+}
+
+// NewQueryClient returns a client for a service speaking the AWS query
+// protocol, for services the vendored SDK does not ship.
+func NewQueryClient(
+	p client.ConfigProvider,
+	service, apiVersion string,
+) *client.Client {
+	c := p.ClientConfig(service)
+	svc := client.New(
+		*c.Config,
+		metadata.ClientInfo{
+			ServiceName:   service,
+			SigningRegion: c.SigningRegion,
+			Endpoint:      c.Endpoint,
+			APIVersion:    apiVersion,
+		},
+		c.Handlers,
+	)
+
+	svc.Handlers.Sign.PushBack(v4.Sign)
+	svc.Handlers.Build.PushBack(query.Build)
+	svc.Handlers.Unmarshal.PushBack(query.Unmarshal)
+	svc.Handlers.UnmarshalMeta.PushBack(query.UnmarshalMeta)
+	svc.Handlers.UnmarshalError.PushBack(query.UnmarshalError)
+
+	return svc
+}
+
+func (c *stsClient) AssumeRole(input *assumeRoleInput) (*assumeRoleOutput, error) {
+	op := &request.Operation{
+		Name:       opAssumeRole,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &assumeRoleOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+// assumeRoleDuration is how long assumed role credentials are requested for.
+const assumeRoleDuration = 15 * time.Minute
+
+// assumeRoleProvider retrieves temporary credentials for a role from STS and
+// refreshes them shortly before they expire.
+type assumeRoleProvider struct {
+	credentials.Expiry
+
+	client      stsAPI
+	roleArn     string
+	sessionName string
+	externalId  string
+}
+
+func (p *assumeRoleProvider) Retrieve() (credentials.Value, error) {
+	if p.sessionName == "" {
+		p.sessionName = fmt.Sprintf("telegraf-%d", time.Now().UTC().UnixNano())
+	}
+
+	input := &assumeRoleInput{
+		DurationSeconds: aws.Int64(int64(assumeRoleDuration / time.Second)),
+		RoleArn:         aws.String(p.roleArn),
+		RoleSessionName: aws.String(p.sessionName),
+	}
+	if p.externalId != "" {
+		input.ExternalId = aws.String(p.externalId)
+	}
+
+	resp, err := p.client.AssumeRole(input)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	if resp.Credentials == nil {
+		return credentials.Value{},
+			fmt.Errorf("no credentials returned assuming role %s", p.roleArn)
+	}
+
+	p.SetExpiration(*resp.Credentials.Expiration, time.Minute)
+
+	return credentials.Value{
+		AccessKeyID:     *resp.Credentials.AccessKeyId,
+		SecretAccessKey: *resp.Credentials.SecretAccessKey,
+		SessionToken:    *resp.Credentials.SessionToken,
+	}, nil
+}
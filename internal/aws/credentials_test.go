@@ -0,0 +1,101 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssumeRoleConfig(t *testing.T) {
+	c := &CredentialConfig{
+		AccessKey: "AKID",
+		SecretKey: "SECRET",
+		RoleArn:   "arn:aws:iam::123456789012:role/telegraf",
+	}
+
+	creds := c.awsConfig("us-east-1").Credentials
+	require.NotNil(t, creds)
+	// The role has not been assumed yet, so the credentials start expired
+	assert.True(t, creds.IsExpired())
+}
+
+func TestStaticCredentials(t *testing.T) {
+	c := &CredentialConfig{AccessKey: "AKID", SecretKey: "SECRET", Token: "TOKEN"}
+
+	creds, err := c.awsConfig("us-east-1").Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "AKID", creds.AccessKeyID)
+	assert.Equal(t, "SECRET", creds.SecretAccessKey)
+	assert.Equal(t, "TOKEN", creds.SessionToken)
+}
+
+func TestSharedCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "telegraf-aws")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	fmt.Fprint(f, "[default]\naws_access_key_id = default\n"+
+		"aws_secret_access_key = default\n\n"+
+		"[production]\naws_access_key_id = prodkey\n"+
+		"aws_secret_access_key = prodsecret\n")
+	f.Close()
+
+	c := &CredentialConfig{SharedCredentialFile: f.Name(), Profile: "production"}
+
+	creds, err := c.awsConfig("us-east-1").Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "prodkey", creds.AccessKeyID)
+	assert.Equal(t, "prodsecret", creds.SecretAccessKey)
+}
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAROLE</AccessKeyId>
+      <SecretAccessKey>rolesecret</SecretAccessKey>
+      <SessionToken>roletoken</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, assumeRoleResponse)
+	}))
+	defer ts.Close()
+
+	// Sign the STS call with static keys and send it to the test server
+	c := &CredentialConfig{AccessKey: "AKID", SecretKey: "SECRET"}
+	cfg := c.awsConfig("us-east-1")
+	cfg.Endpoint = aws.String(ts.URL)
+	provider := &assumeRoleProvider{
+		client:     newSTSClient(session.New(cfg)),
+		roleArn:    "arn:aws:iam::123456789012:role/telegraf",
+		externalId: "tele",
+	}
+
+	creds, err := provider.Retrieve()
+	require.NoError(t, err)
+	assert.Equal(t, "ASIAROLE", creds.AccessKeyID)
+	assert.Equal(t, "rolesecret", creds.SecretAccessKey)
+	assert.Equal(t, "roletoken", creds.SessionToken)
+	assert.False(t, provider.IsExpired())
+
+	assert.Equal(t, "AssumeRole", form.Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/telegraf", form.Get("RoleArn"))
+	assert.Equal(t, "tele", form.Get("ExternalId"))
+	assert.Equal(t, "900", form.Get("DurationSeconds"))
+	assert.NotEmpty(t, form.Get("RoleSessionName"))
+}
@@ -0,0 +1,83 @@
+package aws
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// sessionKey identifies a session. A maxRetries of 0 keeps the default of
+// each service, unless noRetries turns the SDK retries off for callers
+// retrying on their own.
+type sessionKey struct {
+	region     string
+	endpoint   string
+	maxRetries int
+	noRetries  bool
+	creds      CredentialConfig
+}
+
+// Session returns the session for region and endpoint, if not empty, with
+// these credentials. It does not retry failed requests, leaving that to the
+// caller.
+func (c *CredentialConfig) Session(region, endpoint string) *session.Session {
+	return sessions.get(sessionKey{
+		region:    region,
+		endpoint:  endpoint,
+		noRetries: true,
+		creds:     *c,
+	})
+}
+
+// RetryingSession returns the session for region and endpoint, if not empty,
+// with these credentials, retrying failed requests maxRetries times, or as
+// many times as each service does by default if 0.
+func (c *CredentialConfig) RetryingSession(
+	region, endpoint string,
+	maxRetries int,
+) *session.Session {
+	return sessions.get(sessionKey{
+		region:     region,
+		endpoint:   endpoint,
+		maxRetries: maxRetries,
+		creds:      *c,
+	})
+}
+
+// sessionCache shares sessions, and with them the credentials and any
+// assumed role, between all plugins and outputs talking to AWS.
+type sessionCache struct {
+	sync.Mutex
+	sessions map[sessionKey]*session.Session
+}
+
+var sessions = &sessionCache{}
+
+// get returns the session for key, creating it on first use.
+func (c *sessionCache) get(key sessionKey) *session.Session {
+	c.Lock()
+	defer c.Unlock()
+
+	if sess, ok := c.sessions[key]; ok {
+		return sess
+	}
+
+	cfg := key.creds.awsConfig(key.region)
+	if key.endpoint != "" {
+		cfg.Endpoint = aws.String(key.endpoint)
+	}
+	switch {
+	case key.noRetries:
+		cfg.MaxRetries = aws.Int(0)
+	case key.maxRetries > 0:
+		cfg.MaxRetries = aws.Int(key.maxRetries)
+	}
+	sess := session.New(cfg)
+
+	if c.sessions == nil {
+		c.sessions = make(map[sessionKey]*session.Session)
+	}
+	c.sessions[key] = sess
+	return sess
+}
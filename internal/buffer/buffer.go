@@ -0,0 +1,108 @@
+package buffer
+
+import (
+	"sync"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Buffer is a first-in first-out queue of points held in memory. It holds
+// at most limit points: adding points to a full buffer drops the oldest.
+type Buffer struct {
+	sync.Mutex
+
+	// points is a ring of limit slots, holding size points from start.
+	points []*client.Point
+	start  int
+	size   int
+	// head is the sequence number of the oldest point, counting every point
+	// ever added, so that acknowledging a batch never removes points added
+	// after it was taken.
+	head    uint64
+	dropped int64
+}
+
+// NewBuffer returns a buffer holding at most limit points, at least one.
+func NewBuffer(limit int) *Buffer {
+	if limit < 1 {
+		limit = 1
+	}
+	return &Buffer{points: make([]*client.Point, limit)}
+}
+
+// Add appends points, dropping the oldest points past the limit.
+func (b *Buffer) Add(points ...*client.Point) {
+	b.Lock()
+	defer b.Unlock()
+
+	limit := len(b.points)
+	for _, p := range points {
+		if b.size == limit {
+			// Overwrite the oldest point
+			b.points[b.start] = p
+			b.start = (b.start + 1) % limit
+			b.head++
+			b.dropped++
+			continue
+		}
+		b.points[(b.start+b.size)%limit] = p
+		b.size++
+	}
+}
+
+// Batch returns up to size of the oldest points, without removing them, and
+// the sequence number of the first one to acknowledge them once written.
+func (b *Buffer) Batch(size int) ([]*client.Point, uint64) {
+	b.Lock()
+	defer b.Unlock()
+
+	if size > b.size {
+		size = b.size
+	}
+	batch := make([]*client.Point, size)
+	n := copy(batch, b.points[b.start:])
+	copy(batch[n:], b.points)
+	return batch, b.head
+}
+
+// Ack removes the n points of the batch starting at sequence number first.
+// Points of the batch dropped meanwhile are skipped.
+func (b *Buffer) Ack(first uint64, n int) {
+	b.Lock()
+	defer b.Unlock()
+
+	end := first + uint64(n)
+	if end <= b.head {
+		return
+	}
+	remove := int(end - b.head)
+	if remove > b.size {
+		remove = b.size
+	}
+	b.removeOldest(remove)
+}
+
+// Len returns the number of points in the buffer.
+func (b *Buffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.size
+}
+
+// Dropped returns the number of points dropped because the buffer was full.
+func (b *Buffer) Dropped() int64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.dropped
+}
+
+func (b *Buffer) removeOldest(n int) {
+	limit := len(b.points)
+	for i := 0; i < n; i++ {
+		// Release the points for the garbage collector
+		b.points[(b.start+i)%limit] = nil
+	}
+	b.start = (b.start + n) % limit
+	b.size -= n
+	b.head += uint64(n)
+}
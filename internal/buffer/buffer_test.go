@@ -0,0 +1,75 @@
+package buffer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(5)
+	b.Add(testPoints(t, "cpu", 3)...)
+	assert.Equal(t, 3, b.Len())
+
+	batch, first := b.Batch(2)
+	assert.Len(t, batch, 2)
+	assert.Equal(t, int64(0), batch[0].Fields()["count"])
+
+	b.Ack(first, len(batch))
+	assert.Equal(t, 1, b.Len())
+
+	batch, _ = b.Batch(10)
+	assert.Len(t, batch, 1)
+	assert.Equal(t, int64(2), batch[0].Fields()["count"])
+	assert.Equal(t, int64(0), b.Dropped())
+}
+
+func TestBufferDropsOldest(t *testing.T) {
+	b := NewBuffer(5)
+	b.Add(testPoints(t, "cpu", 4)...)
+	batch, first := b.Batch(3)
+
+	// Points 0 to 2 are dropped while the batch is written
+	b.Add(testPoints(t, "mem", 4)...)
+	assert.Equal(t, 5, b.Len())
+	assert.Equal(t, int64(3), b.Dropped())
+
+	// so acknowledging it only removes what is left of it
+	b.Ack(first, len(batch))
+	assert.Equal(t, 5, b.Len())
+
+	batch, first = b.Batch(5)
+	assert.Equal(t, "cpu", batch[0].Name())
+	assert.Equal(t, "mem", batch[1].Name())
+
+	b.Ack(first, 2)
+	assert.Equal(t, 3, b.Len())
+}
+
+func TestBufferWrapsAround(t *testing.T) {
+	b := NewBuffer(3)
+	for i := 0; i < 5; i++ {
+		b.Add(testPoints(t, "cpu", 1)...)
+		batch, first := b.Batch(1)
+		if i%2 == 0 {
+			// Acknowledge every other point, so the ring wraps around
+			b.Ack(first, len(batch))
+		}
+	}
+	// One cpu point is left, and the oldest is dropped to make room
+	b.Add(testPoints(t, "mem", 2)...)
+	assert.Equal(t, 3, b.Len())
+	assert.Equal(t, int64(1), b.Dropped())
+
+	batch, first := b.Batch(10)
+	require.Len(t, batch, 3)
+	assert.Equal(t, "cpu", batch[0].Name())
+	assert.Equal(t, "mem", batch[1].Name())
+	assert.Equal(t, "mem", batch[2].Name())
+
+	b.Ack(first, 2)
+	batch, _ = b.Batch(10)
+	require.Len(t, batch, 1)
+	assert.Equal(t, "mem", batch[0].Name())
+}
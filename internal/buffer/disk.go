@@ -0,0 +1,253 @@
+// Package buffer holds the queues the agent keeps points in until an output
+// has accepted them.
+package buffer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdb/influxdb/client/v2"
+	"github.com/influxdb/influxdb/models"
+)
+
+const batchSuffix = ".batch"
+
+// DiskQueue is a first-in first-out queue of batches of points stored in a
+// directory, one file per batch in line protocol, so that the batches an
+// output has not accepted survive restarts.
+//
+// The queue holds at most maxBytes: pushing a batch past the limit evicts
+// the oldest batches first.
+type DiskQueue struct {
+	sync.Mutex
+
+	dir      string
+	maxBytes int64
+
+	segments []segment
+	bytes    int64
+	points   int64
+	nextID   uint64
+	evicted  int64
+}
+
+// segment is a batch stored in the queue.
+type segment struct {
+	id     uint64
+	bytes  int64
+	points int64
+}
+
+// QueueStats describes the content of a queue.
+type QueueStats struct {
+	Batches int64
+	Points  int64
+	Bytes   int64
+	// Evicted counts the points evicted to respect the size limit since the
+	// queue was opened.
+	Evicted int64
+}
+
+// NewDiskQueue opens the queue stored in dir, creating the directory if
+// needed. Batches left by a previous run are kept, oldest first.
+func NewDiskQueue(dir string, maxBytes int64) (*DiskQueue, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("disk buffer size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &DiskQueue{dir: dir, maxBytes: maxBytes, nextID: 1}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// A batch whose write was interrupted
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if !strings.HasSuffix(name, batchSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, batchSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		q.add(segment{
+			id:     id,
+			bytes:  int64(len(data)),
+			points: int64(bytes.Count(data, []byte("\n"))),
+		})
+		if id >= q.nextID {
+			q.nextID = id + 1
+		}
+	}
+	sort.Sort(byID(q.segments))
+	return q, nil
+}
+
+// Push stores points as a new batch, then evicts the oldest batches until
+// the queue fits its size limit. The new batch is kept even if it is larger
+// than the limit on its own.
+func (q *DiskQueue) Push(points []*client.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, p := range points {
+		buf.WriteString(p.String())
+		buf.WriteByte('\n')
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	id := q.nextID
+	if err := q.write(id, buf.Bytes()); err != nil {
+		return err
+	}
+	q.nextID++
+	q.add(segment{id: id, bytes: int64(buf.Len()), points: int64(len(points))})
+
+	for q.bytes > q.maxBytes && len(q.segments) > 1 {
+		oldest := q.segments[0]
+		if err := os.Remove(q.path(oldest.id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		q.remove(0)
+		q.evicted += oldest.points
+	}
+	return nil
+}
+
+// write stores a batch atomically, so that a crash never leaves a partial
+// batch behind.
+func (q *DiskQueue) write(id uint64, data []byte) error {
+	tmp := q.path(id) + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, q.path(id))
+}
+
+// Peek returns the oldest batch and its ID, to remove it once written. It
+// returns no points if the queue is empty. A batch that cannot be read is
+// returned with an error, so that the caller can remove it.
+func (q *DiskQueue) Peek() (uint64, []*client.Point, error) {
+	q.Lock()
+	if len(q.segments) == 0 {
+		q.Unlock()
+		return 0, nil, nil
+	}
+	id := q.segments[0].id
+	q.Unlock()
+
+	data, err := ioutil.ReadFile(q.path(id))
+	if err != nil {
+		return id, nil, err
+	}
+	parsed, err := models.ParsePoints(data)
+	if err != nil {
+		return id, nil, err
+	}
+
+	points := make([]*client.Point, 0, len(parsed))
+	for _, p := range parsed {
+		pt, err := client.NewPoint(p.Name(), p.Tags(), p.Fields(), p.Time())
+		if err != nil {
+			return id, nil, err
+		}
+		points = append(points, pt)
+	}
+	return id, points, nil
+}
+
+// Remove deletes the batch id. Removing a batch that was already evicted
+// does nothing.
+func (q *DiskQueue) Remove(id uint64) error {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, s := range q.segments {
+		if s.id != id {
+			continue
+		}
+		if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		q.remove(i)
+		return nil
+	}
+	return nil
+}
+
+// Len returns the number of batches in the queue.
+func (q *DiskQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.segments)
+}
+
+// Stats returns the current content of the queue.
+func (q *DiskQueue) Stats() QueueStats {
+	q.Lock()
+	defer q.Unlock()
+	return QueueStats{
+		Batches: int64(len(q.segments)),
+		Points:  q.points,
+		Bytes:   q.bytes,
+		Evicted: q.evicted,
+	}
+}
+
+func (q *DiskQueue) path(id uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, batchSuffix))
+}
+
+func (q *DiskQueue) add(s segment) {
+	q.segments = append(q.segments, s)
+	q.bytes += s.bytes
+	q.points += s.points
+}
+
+func (q *DiskQueue) remove(i int) {
+	s := q.segments[i]
+	q.segments = append(q.segments[:i], q.segments[i+1:]...)
+	q.bytes -= s.bytes
+	q.points -= s.points
+}
+
+type byID []segment
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Less(i, j int) bool { return s[i].id < s[j].id }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
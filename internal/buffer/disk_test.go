@@ -0,0 +1,155 @@
+package buffer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdb/influxdb/client/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPoints(t *testing.T, measurement string, n int) []*client.Point {
+	var points []*client.Point
+	for i := 0; i < n; i++ {
+		p, err := client.NewPoint(measurement,
+			map[string]string{"host": "localhost"},
+			map[string]interface{}{"count": int64(i), "value": 1.5},
+			time.Unix(1448928000+int64(i), 0))
+		require.NoError(t, err)
+		points = append(points, p)
+	}
+	return points
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "telegraf-buffer")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestDiskQueue(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := NewDiskQueue(dir, 1<<20)
+	require.NoError(t, err)
+
+	first := testPoints(t, "cpu", 3)
+	require.NoError(t, q.Push(first))
+	require.NoError(t, q.Push(testPoints(t, "mem", 2)))
+	require.NoError(t, q.Push(nil))
+	assert.Equal(t, 2, q.Len())
+
+	stats := q.Stats()
+	assert.Equal(t, int64(2), stats.Batches)
+	assert.Equal(t, int64(5), stats.Points)
+	assert.True(t, stats.Bytes > 0)
+
+	id, points, err := q.Peek()
+	require.NoError(t, err)
+	require.Len(t, points, 3)
+	for i, p := range points {
+		assert.Equal(t, first[i].String(), p.String())
+	}
+	assert.Equal(t, int64(1), points[1].Fields()["count"])
+
+	require.NoError(t, q.Remove(id))
+	// Removing twice does nothing
+	require.NoError(t, q.Remove(id))
+
+	_, points, err = q.Peek()
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	assert.Equal(t, "mem", points[0].Name())
+}
+
+func TestDiskQueueReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	q, err := NewDiskQueue(dir, 1<<20)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(testPoints(t, "cpu", 3)))
+	require.NoError(t, q.Push(testPoints(t, "mem", 2)))
+
+	// An interrupted write and unrelated files are ignored
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "00000000000000000003.batch.tmp"), []byte("cpu"), 0644))
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "README"), []byte("notes"), 0644))
+
+	q, err = NewDiskQueue(dir, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), q.Stats().Points)
+
+	_, points, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, "cpu", points[0].Name())
+
+	// New batches are queued after the existing ones
+	require.NoError(t, q.Push(testPoints(t, "disk", 1)))
+	for _, name := range []string{"cpu", "mem", "disk"} {
+		id, points, err := q.Peek()
+		require.NoError(t, err)
+		assert.Equal(t, name, points[0].Name())
+		require.NoError(t, q.Remove(id))
+	}
+	assert.Equal(t, 0, q.Len())
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000003.batch.tmp"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskQueueEvictsOldest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	batch := testPoints(t, "cpu0", 10)
+	var size int64
+	for _, p := range batch {
+		size += int64(len(p.String()) + 1)
+	}
+
+	q, err := NewDiskQueue(dir, 2*size)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(testPoints(t, "cpu1", 10)))
+	require.NoError(t, q.Push(testPoints(t, "cpu2", 10)))
+	require.NoError(t, q.Push(testPoints(t, "cpu3", 10)))
+
+	stats := q.Stats()
+	assert.Equal(t, int64(2), stats.Batches)
+	assert.Equal(t, int64(10), stats.Evicted)
+	assert.Equal(t, 2*size, stats.Bytes)
+
+	id, points, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, "cpu2", points[0].Name())
+
+	// A batch larger than the limit replaces everything else
+	require.NoError(t, q.Push(testPoints(t, "large", 30)))
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, int64(30), q.Stats().Evicted)
+
+	// The batch being written was evicted meanwhile
+	require.NoError(t, q.Remove(id))
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestDiskQueueCorruptBatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(
+		filepath.Join(dir, "00000000000000000001.batch"), []byte("cpu value=\n"),
+		0644))
+	q, err := NewDiskQueue(dir, 1<<20)
+	require.NoError(t, err)
+
+	id, _, err := q.Peek()
+	assert.Error(t, err)
+	require.NoError(t, q.Remove(id))
+	assert.Equal(t, 0, q.Len())
+}
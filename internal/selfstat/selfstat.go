@@ -0,0 +1,117 @@
+// Package selfstat holds the statistics the agent keeps about itself, such
+// as how long each plugin takes to gather, for the internal plugin to report.
+package selfstat
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Stat is a single field of a self statistic, either a counter or a gauge.
+type Stat struct {
+	value int64
+
+	measurement string
+	field       string
+	tags        map[string]string
+}
+
+// Incr adds n to the stat.
+func (s *Stat) Incr(n int64) {
+	atomic.AddInt64(&s.value, n)
+}
+
+// Set sets the stat to v.
+func (s *Stat) Set(v int64) {
+	atomic.StoreInt64(&s.value, v)
+}
+
+// Get returns the current value of the stat.
+func (s *Stat) Get() int64 {
+	return atomic.LoadInt64(&s.value)
+}
+
+// Metric is the current value of the stats sharing a measurement and tags.
+type Metric struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+}
+
+var registry = struct {
+	sync.Mutex
+	// stats are keyed by measurement and tags, then by field
+	stats map[string]map[string]*Stat
+}{stats: make(map[string]map[string]*Stat)}
+
+// Register returns the stat for field of measurement with tags, creating it
+// the first time, so that a plugin or output loaded again keeps counting
+// where it left off.
+func Register(measurement, field string, tags map[string]string) *Stat {
+	registry.Lock()
+	defer registry.Unlock()
+
+	key := statKey(measurement, tags)
+	fields, ok := registry.stats[key]
+	if !ok {
+		fields = make(map[string]*Stat)
+		registry.stats[key] = fields
+	}
+	if s, ok := fields[field]; ok {
+		return s
+	}
+
+	s := &Stat{measurement: measurement, field: field, tags: copyTags(tags)}
+	fields[field] = s
+	return s
+}
+
+// Metrics returns the current value of every registered stat, grouped by
+// measurement and tags, in a stable order.
+func Metrics() []Metric {
+	registry.Lock()
+	defer registry.Unlock()
+
+	keys := make([]string, 0, len(registry.stats))
+	for key := range registry.stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metrics := make([]Metric, 0, len(keys))
+	for _, key := range keys {
+		var m Metric
+		m.Fields = make(map[string]interface{})
+		for field, s := range registry.stats[key] {
+			m.Measurement = s.measurement
+			m.Tags = copyTags(s.tags)
+			m.Fields[field] = s.Get()
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func statKey(measurement string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{measurement}
+	for _, k := range keys {
+		parts = append(parts, k+"="+tags[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
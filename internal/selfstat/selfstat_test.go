@@ -0,0 +1,44 @@
+package selfstat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	errs := Register("test_gather", "errors", map[string]string{"plugin": "a"})
+	errs.Incr(2)
+	errs.Incr(1)
+	assert.Equal(t, int64(3), errs.Get())
+
+	// Registering again returns the same stat
+	same := Register("test_gather", "errors", map[string]string{"plugin": "a"})
+	assert.Equal(t, int64(3), same.Get())
+
+	Register("test_gather", "gather_time_ns", map[string]string{"plugin": "a"}).
+		Set(42)
+	Register("test_gather", "errors", map[string]string{"plugin": "b"})
+
+	var found []Metric
+	for _, m := range Metrics() {
+		if m.Measurement == "test_gather" {
+			found = append(found, m)
+		}
+	}
+	assert.Equal(t, []Metric{
+		{
+			Measurement: "test_gather",
+			Tags:        map[string]string{"plugin": "a"},
+			Fields: map[string]interface{}{
+				"errors":         int64(3),
+				"gather_time_ns": int64(42),
+			},
+		},
+		{
+			Measurement: "test_gather",
+			Tags:        map[string]string{"plugin": "b"},
+			Fields:      map[string]interface{}{"errors": int64(0)},
+		},
+	}, found)
+}
@@ -3,6 +3,7 @@ package all
 import (
 	_ "github.com/influxdb/telegraf/outputs/amon"
 	_ "github.com/influxdb/telegraf/outputs/amqp"
+	_ "github.com/influxdb/telegraf/outputs/cloudwatch"
 	_ "github.com/influxdb/telegraf/outputs/datadog"
 	_ "github.com/influxdb/telegraf/outputs/influxdb"
 	_ "github.com/influxdb/telegraf/outputs/kafka"
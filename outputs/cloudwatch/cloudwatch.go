@@ -0,0 +1,356 @@
+package cloudwatch
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/influxdb/client/v2"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/influxdb/telegraf/outputs"
+)
+
+const (
+	// maxDatumsPerRequest is the number of datums PutMetricData accepts in
+	// a single request.
+	maxDatumsPerRequest = 20
+	// maxDimensions is the number of dimensions a datum may carry.
+	maxDimensions = 10
+
+	defaultNamespace  = "Telegraf"
+	defaultMaxRetries = 3
+	// retryBackoff is the wait before the first retry of a failed request.
+	// It doubles with every retry.
+	retryBackoff = 500 * time.Millisecond
+)
+
+type CloudWatch struct {
+	Region string
+	// EndpointURL overrides the CloudWatch endpoint, e.g. to use a local
+	// CloudWatch-compatible server instead of AWS.
+	EndpointURL string `toml:"endpoint_url"`
+
+	internalaws.CredentialConfig
+
+	// Namespace is a template for the namespace of each point, executed
+	// with the point's Name and Tags.
+	Namespace string
+	// DimensionTags lists the tags sent as dimensions. All tags are sent
+	// when it is empty.
+	DimensionTags []string
+	// HighResolution stores metrics with one second resolution.
+	HighResolution bool
+	// MaxRetries is how often a failed request is retried.
+	MaxRetries int
+
+	svc       cloudwatchiface.CloudWatchAPI
+	namespace *template.Template
+	// sleep waits between retries, replaced in tests.
+	sleep func(time.Duration)
+}
+
+var sampleConfig = `
+  # Amazon region
+  region = "us-east-1"
+
+  # Credentials, by default the SDK chain (environment, shared credentials
+  # file, EC2 instance role) is used
+  # access_key = ""
+  # secret_key = ""
+  # token = ""
+  # profile = ""
+  # shared_credential_file = ""
+  # use_instance_role = false
+  # role_arn = ""
+  # external_id = ""
+  # role_session_name = ""
+
+  # Override the CloudWatch endpoint, e.g. for a local mock server
+  # endpoint_url = "http://localhost:4582"
+
+  # Namespace of the metrics, a Go template with the point's .Name and .Tags
+  namespace = "Telegraf/{{ .Name }}"
+
+  # Tags to send as dimensions, all tags if empty. At most 10 are sent.
+  # dimension_tags = ["host"]
+
+  # Store metrics with one second resolution
+  # high_resolution = false
+
+  # Number of times a failed request is retried
+  # max_retries = 3
+`
+
+func (c *CloudWatch) SampleConfig() string {
+	return sampleConfig
+}
+
+func (c *CloudWatch) Description() string {
+	return "Configuration for AWS CloudWatch output."
+}
+
+func (c *CloudWatch) Connect() error {
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	tmpl, err := template.New("namespace").Parse(namespace)
+	if err != nil {
+		return fmt.Errorf("invalid namespace template: %s", err)
+	}
+	c.namespace = tmpl
+
+	if c.sleep == nil {
+		c.sleep = time.Sleep
+	}
+
+	if c.svc != nil {
+		return nil
+	}
+
+	// put retries failed requests, so the session does not
+	svc := cloudwatch.New(c.CredentialConfig.Session(c.Region, c.EndpointURL))
+	if c.HighResolution {
+		c.svc = &highResolutionClient{svc}
+	} else {
+		c.svc = svc
+	}
+	return nil
+}
+
+func (c *CloudWatch) Close() error {
+	return nil
+}
+
+// Write converts every numeric field to a datum and sends the datums,
+// grouped by namespace, in batches of maxDatumsPerRequest. Failed batches
+// are retried; batches that still fail do not stop the others.
+//
+// An error is only returned when no batch was accepted, so that the points
+// written again by the agent are never counted twice by CloudWatch. Once
+// some batches were accepted, the batches that still fail are dropped.
+func (c *CloudWatch) Write(points []*client.Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	var namespaces []string
+	datums := make(map[string][]*cloudwatch.MetricDatum)
+	for _, pt := range points {
+		namespace, err := c.namespaceOf(pt)
+		if err != nil {
+			log.Printf("unable to build namespace for %s, skipping: %s\n",
+				pt.Name(), err)
+			continue
+		}
+		if _, ok := datums[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		datums[namespace] = append(datums[namespace], c.buildDatums(pt)...)
+	}
+
+	var sent, failed int
+	var errs []error
+	for _, namespace := range namespaces {
+		all := datums[namespace]
+		for offset := 0; offset < len(all); offset += maxDatumsPerRequest {
+			limit := offset + maxDatumsPerRequest
+			if limit > len(all) {
+				limit = len(all)
+			}
+			if err := c.put(namespace, all[offset:limit]); err != nil {
+				failed += limit - offset
+				errs = append(errs, fmt.Errorf("namespace %s: %s",
+					namespace, err))
+				continue
+			}
+			sent += limit - offset
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if sent == 0 {
+		return fmt.Errorf("failed to write %d datums to CloudWatch: %s",
+			failed, errs[len(errs)-1])
+	}
+	for _, err := range errs {
+		log.Printf("Error in output [cloudwatch]: dropping a batch of "+
+			"datums: %s\n", err)
+	}
+	log.Printf("Error in output [cloudwatch]: dropped %d of %d datums\n",
+		failed, sent+failed)
+	return nil
+}
+
+// put sends a single batch, retrying it with exponential backoff unless
+// CloudWatch rejected its content.
+func (c *CloudWatch) put(namespace string, datums []*cloudwatch.MetricDatum) error {
+	params := &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(namespace),
+		MetricData: datums,
+	}
+
+	maxRetries := c.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+
+	backoff := retryBackoff
+	for retry := 0; ; retry++ {
+		_, err := c.svc.PutMetricData(params)
+		if err == nil || !isRetryable(err) || retry == maxRetries {
+			return err
+		}
+		c.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isRetryable returns true if err may go away by sending the batch again:
+// throttling, server errors and failed connections. Errors caused by the
+// request itself, including its validation by the SDK, would fail again.
+func isRetryable(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch awsErr.Code() {
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded":
+		return true
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() >= 500
+	}
+	return awsErr.Code() == "RequestError"
+}
+
+// namespaceData is passed to the namespace template.
+type namespaceData struct {
+	Name string
+	Tags map[string]string
+}
+
+func (c *CloudWatch) namespaceOf(pt *client.Point) (string, error) {
+	var buf bytes.Buffer
+	err := c.namespace.Execute(&buf, namespaceData{pt.Name(), pt.Tags()})
+	if err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", fmt.Errorf("namespace is empty")
+	}
+	return buf.String(), nil
+}
+
+// buildDatums returns one datum per numeric field of pt. A field called
+// value is named after the measurement, other fields are named
+// measurement_field.
+func (c *CloudWatch) buildDatums(pt *client.Point) []*cloudwatch.MetricDatum {
+	dims := c.buildDimensions(pt.Tags())
+
+	fields := pt.Fields()
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var datums []*cloudwatch.MetricDatum
+	for _, name := range names {
+		value, ok := floatValue(fields[name])
+		if !ok {
+			continue
+		}
+		metricName := pt.Name()
+		if name != "value" {
+			metricName += "_" + name
+		}
+		datums = append(datums, &cloudwatch.MetricDatum{
+			MetricName: aws.String(metricName),
+			Dimensions: dims,
+			Value:      aws.Float64(value),
+			Timestamp:  aws.Time(pt.Time()),
+		})
+	}
+	return datums
+}
+
+// buildDimensions turns the allowed tags into dimensions, sorted by name and
+// capped at maxDimensions.
+func (c *CloudWatch) buildDimensions(tags map[string]string) []*cloudwatch.Dimension {
+	var keys []string
+	if len(c.DimensionTags) > 0 {
+		for _, k := range c.DimensionTags {
+			if _, ok := tags[k]; ok {
+				keys = append(keys, k)
+			}
+		}
+	} else {
+		for k := range tags {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var dims []*cloudwatch.Dimension
+	for _, k := range keys {
+		if tags[k] == "" {
+			continue
+		}
+		if len(dims) == maxDimensions {
+			break
+		}
+		dims = append(dims, &cloudwatch.Dimension{
+			Name:  aws.String(k),
+			Value: aws.String(tags[k]),
+		})
+	}
+	return dims
+}
+
+// floatValue converts a field value to float64. Booleans become 0 or 1,
+// strings are rejected, and so are NaN and infinities, for which
+// CloudWatch would reject the whole batch.
+func floatValue(v interface{}) (float64, bool) {
+	var f float64
+	switch d := v.(type) {
+	case int:
+		f = float64(d)
+	case int32:
+		f = float64(d)
+	case int64:
+		f = float64(d)
+	case uint64:
+		f = float64(d)
+	case float32:
+		f = float64(d)
+	case float64:
+		f = d
+	case bool:
+		if d {
+			f = 1
+		}
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+func init() {
+	outputs.Add("cloudwatch", func() outputs.Output {
+		return &CloudWatch{}
+	})
+}
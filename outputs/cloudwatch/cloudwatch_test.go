@@ -0,0 +1,291 @@
+package cloudwatch
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/influxdb/client/v2"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockCloudWatchClient records PutMetricData requests. The errors in errs
+// are returned, in order, by the first calls.
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+
+	requests []*cloudwatch.PutMetricDataInput
+	errs     []error
+}
+
+func (c *mockCloudWatchClient) PutMetricData(
+	params *cloudwatch.PutMetricDataInput,
+) (*cloudwatch.PutMetricDataOutput, error) {
+	c.requests = append(c.requests, params)
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func newTestCloudWatch(svc cloudwatchiface.CloudWatchAPI) *CloudWatch {
+	return &CloudWatch{
+		svc:   svc,
+		sleep: func(time.Duration) {},
+	}
+}
+
+func newPoint(
+	name string,
+	tags map[string]string,
+	fields map[string]interface{},
+) *client.Point {
+	pt, err := client.NewPoint(name, tags, fields,
+		time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		panic(err)
+	}
+	return pt
+}
+
+func TestWrite(t *testing.T) {
+	svc := &mockCloudWatchClient{}
+	c := newTestCloudWatch(svc)
+	require.NoError(t, c.Connect())
+
+	err := c.Write([]*client.Point{
+		newPoint("cpu", map[string]string{"host": "a", "cpu": "cpu0"},
+			map[string]interface{}{
+				"usage_idle": 92.5,
+				"usage_user": int64(3),
+				"state":      "ok",
+			}),
+		newPoint("uptime", nil, map[string]interface{}{"value": true}),
+	})
+	require.NoError(t, err)
+
+	require.Len(t, svc.requests, 1)
+	req := svc.requests[0]
+	assert.Equal(t, "Telegraf", *req.Namespace)
+	require.Len(t, req.MetricData, 3)
+
+	d := req.MetricData[0]
+	assert.Equal(t, "cpu_usage_idle", *d.MetricName)
+	assert.Equal(t, 92.5, *d.Value)
+	assert.Equal(t, time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC), *d.Timestamp)
+	require.Len(t, d.Dimensions, 2)
+	assert.Equal(t, "cpu", *d.Dimensions[0].Name)
+	assert.Equal(t, "host", *d.Dimensions[1].Name)
+
+	assert.Equal(t, "cpu_usage_user", *req.MetricData[1].MetricName)
+	assert.Equal(t, 3.0, *req.MetricData[1].Value)
+	assert.Equal(t, "uptime", *req.MetricData[2].MetricName)
+	assert.Equal(t, 1.0, *req.MetricData[2].Value)
+}
+
+func TestFloatValue(t *testing.T) {
+	for _, v := range []interface{}{int64(3), 3.0, float32(3), uint64(3)} {
+		f, ok := floatValue(v)
+		assert.True(t, ok, "%T", v)
+		assert.Equal(t, 3.0, f, "%T", v)
+	}
+
+	// CloudWatch rejects a whole batch holding any of these
+	for _, v := range []interface{}{
+		"3", math.NaN(), math.Inf(1), math.Inf(-1), float32(math.Inf(1)),
+	} {
+		_, ok := floatValue(v)
+		assert.False(t, ok, "%v", v)
+	}
+}
+
+func TestWriteBatches(t *testing.T) {
+	svc := &mockCloudWatchClient{}
+	c := newTestCloudWatch(svc)
+	require.NoError(t, c.Connect())
+
+	var points []*client.Point
+	for i := 0; i < 2*maxDatumsPerRequest+5; i++ {
+		points = append(points, newPoint("requests", nil,
+			map[string]interface{}{"value": i}))
+	}
+	require.NoError(t, c.Write(points))
+
+	require.Len(t, svc.requests, 3)
+	assert.Len(t, svc.requests[0].MetricData, maxDatumsPerRequest)
+	assert.Len(t, svc.requests[1].MetricData, maxDatumsPerRequest)
+	assert.Len(t, svc.requests[2].MetricData, 5)
+}
+
+func TestWriteNamespaceTemplate(t *testing.T) {
+	svc := &mockCloudWatchClient{}
+	c := newTestCloudWatch(svc)
+	c.Namespace = "Telegraf/{{ .Tags.env }}/{{ .Name }}"
+	require.NoError(t, c.Connect())
+
+	require.NoError(t, c.Write([]*client.Point{
+		newPoint("cpu", map[string]string{"env": "prod"},
+			map[string]interface{}{"value": 1.0}),
+		newPoint("mem", map[string]string{"env": "prod"},
+			map[string]interface{}{"value": 2.0}),
+		newPoint("cpu", map[string]string{"env": "prod"},
+			map[string]interface{}{"value": 3.0}),
+	}))
+
+	require.Len(t, svc.requests, 2)
+	assert.Equal(t, "Telegraf/prod/cpu", *svc.requests[0].Namespace)
+	assert.Len(t, svc.requests[0].MetricData, 2)
+	assert.Equal(t, "Telegraf/prod/mem", *svc.requests[1].Namespace)
+}
+
+func TestBadNamespaceTemplate(t *testing.T) {
+	c := newTestCloudWatch(&mockCloudWatchClient{})
+	c.Namespace = "Telegraf/{{ .Name"
+	assert.Error(t, c.Connect())
+}
+
+func TestBuildDimensions(t *testing.T) {
+	tags := make(map[string]string)
+	for i := 0; i < maxDimensions+5; i++ {
+		tags[fmt.Sprintf("tag%02d", i)] = "x"
+	}
+	tags["empty"] = ""
+
+	c := &CloudWatch{}
+	dims := c.buildDimensions(tags)
+	require.Len(t, dims, maxDimensions)
+	assert.Equal(t, "tag00", *dims[0].Name)
+
+	c.DimensionTags = []string{"tag12", "empty", "missing", "tag03"}
+	dims = c.buildDimensions(tags)
+	require.Len(t, dims, 2)
+	assert.Equal(t, "tag03", *dims[0].Name)
+	assert.Equal(t, "tag12", *dims[1].Name)
+}
+
+func TestWriteRetriesFailedBatches(t *testing.T) {
+	svc := &mockCloudWatchClient{
+		errs: []error{
+			awserr.NewRequestFailure(
+				awserr.New("InternalFailure", "try again", nil), 500, "1"),
+			nil,
+			awserr.NewRequestFailure(
+				awserr.New("InvalidParameterValue", "bad value", nil), 400, "2"),
+		},
+	}
+	c := newTestCloudWatch(svc)
+	require.NoError(t, c.Connect())
+
+	var points []*client.Point
+	for i := 0; i < 3*maxDatumsPerRequest; i++ {
+		points = append(points, newPoint("requests", nil,
+			map[string]interface{}{"value": i}))
+	}
+	// The rejected batch is dropped, as the others were accepted and
+	// writing them again would count them twice
+	require.NoError(t, c.Write(points))
+
+	// The first batch is retried, the rejected second batch is not and the
+	// third batch is still sent
+	assert.Len(t, svc.requests, 4)
+}
+
+func TestWriteFailsWhenNothingSent(t *testing.T) {
+	rejected := awserr.NewRequestFailure(
+		awserr.New("InvalidParameterValue", "bad value", nil), 400, "1")
+	svc := &mockCloudWatchClient{errs: []error{rejected, rejected}}
+	c := newTestCloudWatch(svc)
+	require.NoError(t, c.Connect())
+
+	var points []*client.Point
+	for i := 0; i < 2*maxDatumsPerRequest; i++ {
+		points = append(points, newPoint("requests", nil,
+			map[string]interface{}{"value": i}))
+	}
+	err := c.Write(points)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		fmt.Sprintf("failed to write %d datums", 2*maxDatumsPerRequest))
+	assert.Len(t, svc.requests, 2)
+}
+
+func TestWriteGivesUp(t *testing.T) {
+	throttled := awserr.NewRequestFailure(
+		awserr.New("Throttling", "rate exceeded", nil), 400, "1")
+	svc := &mockCloudWatchClient{
+		errs: []error{throttled, throttled, throttled},
+	}
+	var waits []time.Duration
+	c := newTestCloudWatch(svc)
+	c.MaxRetries = 2
+	c.sleep = func(d time.Duration) { waits = append(waits, d) }
+	require.NoError(t, c.Connect())
+
+	err := c.Write(testutil.MockBatchPoints().Points())
+	require.Error(t, err)
+	assert.Len(t, svc.requests, 3)
+	assert.Equal(t, []time.Duration{retryBackoff, 2 * retryBackoff}, waits)
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(awserr.NewRequestFailure(
+		awserr.New("Throttling", "rate exceeded", nil), 400, "1")))
+	assert.True(t, isRetryable(awserr.NewRequestFailure(
+		awserr.New("InternalFailure", "try again", nil), 500, "1")))
+	assert.True(t, isRetryable(
+		awserr.New("RequestError", "send request failed", nil)))
+
+	assert.False(t, isRetryable(awserr.NewRequestFailure(
+		awserr.New("InvalidParameterValue", "bad value", nil), 400, "1")))
+	assert.False(t, isRetryable(
+		awserr.New("InvalidParameter", "1 validation error(s) found.", nil)))
+	assert.False(t, isRetryable(fmt.Errorf("unexpected")))
+}
+
+func TestHighResolutionClient(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, `<PutMetricDataResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</PutMetricDataResponse>`)
+	}))
+	defer ts.Close()
+
+	sess := session.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ts.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	c := newTestCloudWatch(&highResolutionClient{cloudwatch.New(sess)})
+	require.NoError(t, c.Connect())
+
+	require.NoError(t, c.Write([]*client.Point{
+		newPoint("cpu", map[string]string{"host": "a"},
+			map[string]interface{}{"value": 1.5}),
+	}))
+
+	assert.Equal(t, "PutMetricData", form.Get("Action"))
+	assert.Equal(t, "Telegraf", form.Get("Namespace"))
+	assert.Equal(t, "cpu", form.Get("MetricData.member.1.MetricName"))
+	assert.Equal(t, "1.5", form.Get("MetricData.member.1.Value"))
+	assert.Equal(t, "1", form.Get("MetricData.member.1.StorageResolution"))
+	assert.Equal(t, "host", form.Get("MetricData.member.1.Dimensions.member.1.Name"))
+}
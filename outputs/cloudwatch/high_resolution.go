@@ -0,0 +1,63 @@
+package cloudwatch
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// highResolution is the StorageResolution, in seconds, of high-resolution
+// metrics.
+const highResolution = 1
+
+// The vendored SDK predates StorageResolution, so the request shapes are
+// declared here. They are marshalled by the SDK's query protocol handlers
+// exactly like the generated types in service/cloudwatch.
+
+type metricDatum struct {
+	Dimensions        []*cloudwatch.Dimension `type:"list"`
+	MetricName        *string                 `min:"1" type:"string" required:"true"`
+	StorageResolution *int64                  `min:"1" type:"integer"`
+	Timestamp         *time.Time              `type:"timestamp" timestampFormat:"iso8601"`
+	Unit              *string                 `type:"string"`
+	Value             *float64                `type:"double"`
+}
+
+type putMetricDataInput struct {
+	MetricData []*metricDatum `type:"list" required:"true"`
+	Namespace  *string        `min:"1" type:"string" required:"true"`
+}
+
+// highResolutionClient sends every datum written through PutMetricData with
+// one second storage resolution.
+type highResolutionClient struct {
+	*cloudwatch.CloudWatch
+}
+
+func (c *highResolutionClient) PutMetricData(
+	input *cloudwatch.PutMetricDataInput,
+) (*cloudwatch.PutMetricDataOutput, error) {
+	params := &putMetricDataInput{Namespace: input.Namespace}
+	for _, d := range input.MetricData {
+		params.MetricData = append(params.MetricData, &metricDatum{
+			Dimensions:        d.Dimensions,
+			MetricName:        d.MetricName,
+			StorageResolution: aws.Int64(highResolution),
+			Timestamp:         d.Timestamp,
+			Unit:              d.Unit,
+			Value:             d.Value,
+		})
+	}
+
+	op := &request.Operation{
+		Name:       "PutMetricData",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &cloudwatch.PutMetricDataOutput{}
+	req := c.NewRequest(op, params, output)
+	err := req.Send()
+	return output, err
+}
@@ -0,0 +1,139 @@
+package aws
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// Alarms selects the CloudWatch alarms whose state is reported.
+type Alarms struct {
+	Region string
+	// Regions reports the alarms of each of these regions.
+	Regions []string
+	// NamePrefix only reports alarms whose name starts with it.
+	NamePrefix string
+	// States only reports alarms in one of these states: OK, ALARM or
+	// INSUFFICIENT_DATA.
+	States []string
+
+	internalaws.CredentialConfig
+}
+
+// alarmStates maps each alarm state to the value of the numeric state
+// field, ordered by severity so the worst state is the largest.
+var alarmStates = map[string]int64{
+	"OK":                0,
+	"INSUFFICIENT_DATA": 1,
+	"ALARM":             2,
+}
+
+// regions returns the regions whose alarms are reported.
+func (a *Alarms) regions() []string {
+	if len(a.Regions) > 0 {
+		return a.Regions
+	}
+	return []string{a.Region}
+}
+
+// gather writes one point per alarm of the region with its state, threshold
+// and the time of the last state change.
+func (a *Alarms) gather(
+	svc cloudwatchiface.CloudWatchAPI,
+	acc plugins.Accumulator,
+	region string,
+) error {
+	params := &cloudwatch.DescribeAlarmsInput{}
+	if a.NamePrefix != "" {
+		params.AlarmNamePrefix = aws.String(a.NamePrefix)
+	}
+	// The API filters on a single state, more are filtered below
+	if len(a.States) == 1 {
+		params.StateValue = aws.String(a.States[0])
+	}
+
+	var alarms []*cloudwatch.MetricAlarm
+	err := svc.DescribeAlarmsPages(params,
+		func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+			alarms = append(alarms, page.MetricAlarms...)
+			return true
+		})
+	if err != nil {
+		return fmt.Errorf("describing alarms in %s: %s", region, err)
+	}
+
+	for _, alarm := range alarms {
+		state := aws.StringValue(alarm.StateValue)
+		if !a.wants(state) {
+			continue
+		}
+
+		tags := dimsToTags(alarm.Dimensions)
+		tags["alarm_name"] = aws.StringValue(alarm.AlarmName)
+		tags["state"] = state
+		tags["namespace"] = aws.StringValue(alarm.Namespace)
+		tags["metric_name"] = aws.StringValue(alarm.MetricName)
+		tags["region"] = region
+		if alarm.Statistic != nil {
+			tags["statistic"] = *alarm.Statistic
+		}
+		// Alarms on metric math or composite alarms have no namespace,
+		// metric or dimensions, and empty tags are not valid line protocol
+		for k, v := range tags {
+			if v == "" {
+				delete(tags, k)
+			}
+		}
+
+		fields := map[string]interface{}{
+			"state": alarmStates[state],
+		}
+		if alarm.Threshold != nil {
+			fields["threshold"] = *alarm.Threshold
+		}
+		if alarm.StateUpdatedTimestamp != nil {
+			fields["state_updated"] = alarm.StateUpdatedTimestamp.Unix()
+		}
+
+		acc.AddFields("alarm", fields, tags)
+	}
+	return nil
+}
+
+// wants returns true if alarms in state are reported.
+func (a *Alarms) wants(state string) bool {
+	if len(a.States) == 0 {
+		return true
+	}
+	for _, s := range a.States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns the problems with the configuration of an alarms block.
+func (a *Alarms) validate() []error {
+	var errs []error
+	if a.Region == "" && len(a.Regions) == 0 {
+		errs = append(errs, fmt.Errorf("region is not set"))
+	}
+	for _, region := range a.Regions {
+		if region == "" {
+			errs = append(errs, fmt.Errorf("regions contains an empty region"))
+		}
+	}
+	for _, state := range a.States {
+		if _, ok := alarmStates[state]; !ok {
+			errs = append(errs, fmt.Errorf(
+				"unknown alarm state %q, expected one of OK, ALARM, "+
+					"INSUFFICIENT_DATA", state))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAlarmsClient serves DescribeAlarms, one alarm per page.
+type mockAlarmsClient struct {
+	cloudwatchiface.CloudWatchAPI
+
+	alarms   []*cloudwatch.MetricAlarm
+	requests []*cloudwatch.DescribeAlarmsInput
+}
+
+func (c *mockAlarmsClient) DescribeAlarmsPages(
+	params *cloudwatch.DescribeAlarmsInput,
+	fn func(*cloudwatch.DescribeAlarmsOutput, bool) bool,
+) error {
+	c.requests = append(c.requests, params)
+	for i, alarm := range c.alarms {
+		page := &cloudwatch.DescribeAlarmsOutput{
+			MetricAlarms: []*cloudwatch.MetricAlarm{alarm},
+		}
+		if !fn(page, i == len(c.alarms)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+var stateUpdated = time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC)
+
+func newAlarm(name, state string) *cloudwatch.MetricAlarm {
+	return &cloudwatch.MetricAlarm{
+		AlarmName:  aws.String(name),
+		StateValue: aws.String(state),
+		Namespace:  aws.String("AWS/EC2"),
+		MetricName: aws.String("CPUUtilization"),
+		Statistic:  aws.String("Average"),
+		Threshold:  aws.Float64(80),
+		Dimensions: convertDimensions(map[string]string{
+			"InstanceId": "i-abc",
+		}),
+		StateUpdatedTimestamp: aws.Time(stateUpdated),
+	}
+}
+
+func TestGatherAlarms(t *testing.T) {
+	svc := &mockAlarmsClient{
+		alarms: []*cloudwatch.MetricAlarm{
+			newAlarm("prod-cpu", "ALARM"),
+			newAlarm("prod-disk", "OK"),
+			newAlarm("prod-net", "INSUFFICIENT_DATA"),
+		},
+	}
+	cw := &CloudWatch{
+		Alarms: []Alarms{{
+			Region:     "us-east-1",
+			NamePrefix: "prod-",
+			States:     []string{"ALARM", "INSUFFICIENT_DATA"},
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: newThrottledClient(svc, nil),
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Gather(&acc))
+
+	require.Len(t, svc.requests, 1)
+	assert.Equal(t, "prod-", *svc.requests[0].AlarmNamePrefix)
+	assert.Nil(t, svc.requests[0].StateValue)
+
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckTaggedFieldsValue("alarm",
+		map[string]interface{}{
+			"state":         int64(2),
+			"threshold":     80.0,
+			"state_updated": stateUpdated.Unix(),
+		},
+		map[string]string{
+			"alarm_name":  "prod-cpu",
+			"state":       "ALARM",
+			"namespace":   "AWS/EC2",
+			"metric_name": "CPUUtilization",
+			"statistic":   "Average",
+			"region":      "us-east-1",
+			"InstanceId":  "i-abc",
+		}))
+	assert.True(t, acc.CheckTaggedFieldsValue("alarm",
+		map[string]interface{}{
+			"state":         int64(1),
+			"threshold":     80.0,
+			"state_updated": stateUpdated.Unix(),
+		},
+		map[string]string{
+			"alarm_name":  "prod-net",
+			"state":       "INSUFFICIENT_DATA",
+			"namespace":   "AWS/EC2",
+			"metric_name": "CPUUtilization",
+			"statistic":   "Average",
+			"region":      "us-east-1",
+			"InstanceId":  "i-abc",
+		}))
+}
+
+func TestGatherAlarmsWithoutMetric(t *testing.T) {
+	svc := &mockAlarmsClient{
+		alarms: []*cloudwatch.MetricAlarm{{
+			AlarmName:  aws.String("composite"),
+			StateValue: aws.String("OK"),
+			Namespace:  aws.String(""),
+			Dimensions: convertDimensions(map[string]string{"InstanceId": ""}),
+		}},
+	}
+	a := &Alarms{Region: "us-east-1"}
+
+	var acc testutil.Accumulator
+	require.NoError(t, a.gather(svc, &acc, "us-east-1"))
+
+	assert.True(t, acc.CheckTaggedFieldsValue("alarm",
+		map[string]interface{}{"state": int64(0)},
+		map[string]string{
+			"alarm_name": "composite",
+			"state":      "OK",
+			"region":     "us-east-1",
+		}))
+}
+
+func TestGatherAlarmsSingleState(t *testing.T) {
+	svc := &mockAlarmsClient{}
+	a := &Alarms{Region: "us-east-1", States: []string{"ALARM"}}
+
+	var acc testutil.Accumulator
+	require.NoError(t, a.gather(svc, &acc, "us-east-1"))
+
+	require.Len(t, svc.requests, 1)
+	assert.Equal(t, "ALARM", *svc.requests[0].StateValue)
+	assert.Nil(t, svc.requests[0].AlarmNamePrefix)
+}
+
+func TestValidateAlarms(t *testing.T) {
+	cw := &CloudWatch{Alarms: []Alarms{{Region: "us-east-1"}}}
+	assert.NoError(t, cw.Validate())
+
+	cw = &CloudWatch{Alarms: []Alarms{{States: []string{"FIRING"}}}}
+	err := cw.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "alarms[0]: region is not set")
+	assert.Contains(t, err.Error(), `alarms[0]: unknown alarm state "FIRING"`)
+}
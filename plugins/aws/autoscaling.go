@@ -0,0 +1,162 @@
+package aws
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// AutoScaling reports the size of Auto Scaling groups.
+type AutoScaling struct {
+	ServiceConfig
+
+	// Groups lists the groups to report, by default every group of the
+	// region.
+	Groups []string
+
+	svc autoScalingAPI
+}
+
+var autoScalingSampleConfig = `
+  # Region of the groups
+  region = "us-east-1"
+  # Auto Scaling groups to report, by default every group of the region
+  # groups = ["web", "workers"]
+
+  # Override the Auto Scaling endpoint, e.g. for a local mock server
+  # endpoint_url = "http://localhost:5000"
+  # Number of times a failed or throttled call is retried
+  # max_retries = 3
+
+  # Credentials, by default the SDK chain (environment, shared credentials
+  # file, EC2 instance role) is used
+  # access_key = ""
+  # secret_key = ""
+  # profile = ""
+  # role_arn = ""
+`
+
+func (a *AutoScaling) SampleConfig() string {
+	return autoScalingSampleConfig
+}
+
+func (a *AutoScaling) Description() string {
+	return "Read the desired and in-service capacity of AWS Auto Scaling groups."
+}
+
+func (a *AutoScaling) Validate() error {
+	var errs errorList
+	for _, err := range a.validate() {
+		errs.add(err)
+	}
+	return errs.err()
+}
+
+// Gather writes one point per Auto Scaling group.
+func (a *AutoScaling) Gather(acc plugins.Accumulator) error {
+	if a.svc == nil {
+		a.svc = newAutoScalingClient(a.session())
+	}
+
+	input := &describeAutoScalingGroupsInput{}
+	if len(a.Groups) > 0 {
+		input.AutoScalingGroupNames = aws.StringSlice(a.Groups)
+	}
+	for {
+		resp, err := a.svc.describeAutoScalingGroups(input)
+		if err != nil {
+			return err
+		}
+
+		for _, group := range resp.AutoScalingGroups {
+			inService := 0
+			for _, instance := range group.Instances {
+				if aws.StringValue(instance.LifecycleState) == "InService" {
+					inService++
+				}
+			}
+
+			fields := map[string]interface{}{
+				"desired_capacity":     aws.Int64Value(group.DesiredCapacity),
+				"min_size":             aws.Int64Value(group.MinSize),
+				"max_size":             aws.Int64Value(group.MaxSize),
+				"instances":            int64(len(group.Instances)),
+				"in_service_instances": int64(inService),
+			}
+			tags := map[string]string{
+				"auto_scaling_group_name": aws.StringValue(
+					group.AutoScalingGroupName),
+				"region": a.Region,
+			}
+			acc.AddFields("group", fields, tags)
+		}
+
+		if aws.StringValue(resp.NextToken) == "" {
+			return nil
+		}
+		input.NextToken = resp.NextToken
+	}
+}
+
+// The vendored SDK does not ship the Auto Scaling service, so the call used
+// by the collector is declared here.
+
+type describeAutoScalingGroupsInput struct {
+	AutoScalingGroupNames []*string `type:"list"`
+	NextToken             *string   `type:"string"`
+}
+
+type autoScalingInstance struct {
+	InstanceId     *string `type:"string"`
+	LifecycleState *string `type:"string"`
+}
+
+type autoScalingGroup struct {
+	AutoScalingGroupName *string                `type:"string"`
+	DesiredCapacity      *int64                 `type:"integer"`
+	MinSize              *int64                 `type:"integer"`
+	MaxSize              *int64                 `type:"integer"`
+	Instances            []*autoScalingInstance `type:"list"`
+}
+
+type describeAutoScalingGroupsOutput struct {
+	AutoScalingGroups []*autoScalingGroup `type:"list"`
+	NextToken         *string             `type:"string"`
+}
+
+// autoScalingAPI is the subset of Auto Scaling used by the collector.
+type autoScalingAPI interface {
+	describeAutoScalingGroups(
+		*describeAutoScalingGroupsInput,
+	) (*describeAutoScalingGroupsOutput, error)
+}
+
+type autoScalingClient struct {
+	*client.Client
+}
+
+func newAutoScalingClient(p client.ConfigProvider) *autoScalingClient {
+	return &autoScalingClient{
+		internalaws.NewQueryClient(p, "autoscaling", "2011-01-01"),
+	}
+}
+
+func (c *autoScalingClient) describeAutoScalingGroups(
+	input *describeAutoScalingGroupsInput,
+) (*describeAutoScalingGroupsOutput, error) {
+	op := &request.Operation{
+		Name:       "DescribeAutoScalingGroups",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &describeAutoScalingGroupsOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func init() {
+	plugins.Add("aws_autoscaling", func() plugins.Plugin { return &AutoScaling{} })
+}
@@ -0,0 +1,81 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const describeAutoScalingGroupsResponse = `<DescribeAutoScalingGroupsResponse>
+  <DescribeAutoScalingGroupsResult>
+    <AutoScalingGroups>
+      <member>
+        <AutoScalingGroupName>%s</AutoScalingGroupName>
+        <DesiredCapacity>3</DesiredCapacity>
+        <MinSize>1</MinSize>
+        <MaxSize>5</MaxSize>
+        <Instances>
+          <member>
+            <InstanceId>i-abc</InstanceId>
+            <LifecycleState>InService</LifecycleState>
+          </member>
+          <member>
+            <InstanceId>i-def</InstanceId>
+            <LifecycleState>Pending</LifecycleState>
+          </member>
+        </Instances>
+      </member>
+    </AutoScalingGroups>
+    %s
+  </DescribeAutoScalingGroupsResult>
+</DescribeAutoScalingGroupsResponse>`
+
+func TestAutoScaling(t *testing.T) {
+	var forms []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		if r.PostForm.Get("NextToken") == "" {
+			fmt.Fprintf(w, describeAutoScalingGroupsResponse,
+				"web", "<NextToken>page2</NextToken>")
+			return
+		}
+		fmt.Fprintf(w, describeAutoScalingGroupsResponse, "workers", "")
+	}))
+	defer ts.Close()
+
+	a := &AutoScaling{
+		ServiceConfig: testServiceConfig(ts.URL),
+		Groups:        []string{"web", "workers"},
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, a.Gather(&acc))
+
+	require.Len(t, forms, 2)
+	assert.Equal(t, "DescribeAutoScalingGroups", forms[0].Get("Action"))
+	assert.Equal(t, "web", forms[0].Get("AutoScalingGroupNames.member.1"))
+	assert.Equal(t, "workers", forms[0].Get("AutoScalingGroupNames.member.2"))
+	assert.Equal(t, "page2", forms[1].Get("NextToken"))
+
+	require.Len(t, acc.Points, 2)
+	for _, name := range []string{"web", "workers"} {
+		assert.True(t, acc.CheckTaggedFieldsValue("group",
+			map[string]interface{}{
+				"desired_capacity":     int64(3),
+				"min_size":             int64(1),
+				"max_size":             int64(5),
+				"instances":            int64(2),
+				"in_service_instances": int64(1),
+			},
+			map[string]string{
+				"auto_scaling_group_name": name,
+				"region":                  "us-east-1",
+			}), name)
+	}
+}
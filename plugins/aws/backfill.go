@@ -0,0 +1,98 @@
+package aws
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// maxDatapoints is the number of datapoints GetMetricStatistics returns per
+// call, which bounds the length of each backfilled chunk.
+const maxDatapoints = 1440
+
+// Backfill gathers every metric block over the range from start to end and
+// adds the datapoints with their original timestamps. Each block requests
+// the range in chunks that fit a single API call per metric; all calls share
+// the rate limit. The state file is neither read nor updated.
+func (cw *CloudWatch) Backfill(
+	acc plugins.Accumulator,
+	start, end time.Time,
+) error {
+	if !start.Before(end) {
+		return fmt.Errorf("backfill start %s is not before end %s",
+			start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+
+	cw.setup()
+	now := cw.now()
+
+	var wg sync.WaitGroup
+	var errs errorList
+	for _, m := range expandRegions(cw.Metrics) {
+		m := m
+		cw.prepare(m)
+		svc := cw.client(m.Region, m.CredentialConfig)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs.add(m.backfill(svc, acc, now, start, end))
+		}()
+	}
+	wg.Wait()
+	cw.usage.write(acc)
+
+	return errs.err()
+}
+
+// backfill gathers the block chunk by chunk, oldest first. It stops at the
+// first chunk with errors, so the range can be resumed from there, except
+// for the percentiles the endpoint cannot collect, reported at the end.
+func (m *Metric) backfill(
+	svc cloudwatchiface.CloudWatchAPI,
+	acc plugins.Accumulator,
+	now, start, end time.Time,
+) error {
+	// The other statistics are still backfilled without the percentiles
+	var unsupported error
+	for _, chunk := range m.chunks(start, end) {
+		err := m.gatherRange(svc, acc, now, chunk[0], chunk[1])
+		if _, ok := err.(*unsupportedStatisticsError); ok {
+			unsupported = err
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("backfilling %s from %s to %s: %s",
+				m.Namespace, chunk[0].Format(time.RFC3339),
+				chunk[1].Format(time.RFC3339), err)
+		}
+	}
+	if unsupported != nil {
+		return fmt.Errorf("backfilling %s: %s", m.Namespace, unsupported)
+	}
+	return nil
+}
+
+// chunks splits the range from start to end, aligned to the period, into
+// pieces of at most maxDatapoints periods.
+func (m *Metric) chunks(start, end time.Time) [][2]time.Time {
+	period := time.Duration(m.Period) * time.Second
+	if period <= 0 {
+		return [][2]time.Time{{start, end}}
+	}
+	start, end = start.Truncate(period), end.Truncate(period)
+
+	var chunks [][2]time.Time
+	for from := start; from.Before(end); {
+		to := from.Add(maxDatapoints * period)
+		if to.After(end) {
+			to = end
+		}
+		chunks = append(chunks, [2]time.Time{from, to})
+		from = to
+	}
+	return chunks
+}
@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunks(t *testing.T) {
+	m := &Metric{Period: 300}
+	start := time.Date(2015, 11, 1, 0, 2, 0, 0, time.UTC)
+	end := time.Date(2015, 11, 11, 0, 0, 0, 0, time.UTC)
+
+	chunks := m.chunks(start, end)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC), chunks[0][0])
+	assert.Equal(t, time.Date(2015, 11, 6, 0, 0, 0, 0, time.UTC), chunks[0][1])
+	assert.Equal(t, chunks[0][1], chunks[1][0])
+	assert.Equal(t, end, chunks[1][1])
+
+	assert.Empty(t, m.chunks(start, start))
+}
+
+func TestBackfill(t *testing.T) {
+	svc := fakeEC2Client()
+	cw := &CloudWatch{
+		StateFile: "/nonexistent/cloudwatch.state",
+		Metrics: []Metric{{
+			Region:      "us-east-1",
+			Namespace:   "AWS/EC2",
+			MetricNames: []string{"CPUUtilization"},
+			Statistics:  []string{"Average"},
+			Period:      60,
+			Duration:    60,
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: svc,
+		},
+	}
+
+	start := time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2015, 11, 2, 12, 0, 0, 0, time.UTC)
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Backfill(&acc, start, end))
+
+	require.Len(t, svc.requests, 2)
+	assert.Equal(t, start, *svc.requests[0].StartTime)
+	assert.Equal(t, start.Add(24*time.Hour), *svc.requests[0].EndTime)
+	assert.Equal(t, start.Add(24*time.Hour), *svc.requests[1].StartTime)
+	assert.Equal(t, end, *svc.requests[1].EndTime)
+
+	// Datapoints keep their original timestamps
+	require.Len(t, acc.Points, 2)
+	assert.Equal(t, start.Add(24*time.Hour-time.Minute), acc.Points[0].Time)
+	assert.Equal(t, end.Add(-time.Minute), acc.Points[1].Time)
+}
+
+func TestBackfillUnsupportedStatistics(t *testing.T) {
+	svc := fakeEC2Client()
+	cw := &CloudWatch{
+		Metrics: []Metric{{
+			Region:             "us-east-1",
+			Namespace:          "AWS/EC2",
+			MetricNames:        []string{"CPUUtilization"},
+			Statistics:         []string{"Average"},
+			ExtendedStatistics: []string{"p99"},
+			Period:             60,
+			Duration:           60,
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: svc,
+		},
+	}
+
+	// Every chunk is backfilled without the percentiles, then they are
+	// reported
+	start := time.Date(2015, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2015, 11, 2, 12, 0, 0, 0, time.UTC)
+	var acc testutil.Accumulator
+	err := cw.Backfill(&acc, start, end)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot collect extended statistics p99")
+	assert.Len(t, svc.requests, 2)
+	assert.Len(t, acc.Points, 2)
+}
+
+func TestBackfillEmptyRange(t *testing.T) {
+	cw := &CloudWatch{}
+	now := time.Now()
+	assert.Error(t, cw.Backfill(&testutil.Accumulator{}, now, now))
+}
@@ -2,51 +2,307 @@ package aws
 
 import (
 	"fmt"
+	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
 	"github.com/influxdb/telegraf/plugins"
 )
 
 var Debug bool
 
 type Metric struct {
-	Region      string
+	Region string
+	// Regions gathers the block in each of these regions. Points are tagged
+	// with their region.
+	Regions     []string
 	MetricNames []string
 	Namespace   string
 	Statistics  []string
 	Period      int64
-	Prefix		string
+	Prefix      string
 	Duration    int64
 	Unit        string
-	Dimensions  map[string]string
+	// Dimensions maps each dimension to one or more values. Values may be
+	// glob patterns, in which case the matching metrics are discovered.
+	Dimensions map[string]DimensionValues
+	// Delay is the number of seconds to stay behind the current time, so that
+	// only periods CloudWatch has finished aggregating are requested.
+	Delay int64
+	// ExtendedStatistics lists percentiles such as p50, p99 or p99.9. They
+	// are only available through GetMetricData.
+	ExtendedStatistics []string
+
+	internalaws.CredentialConfig
+
+	// DimensionNames limits discovered metrics to the ones carrying exactly
+	// this set of dimensions. Only used when MetricNames is empty.
+	DimensionNames []string
+	// CacheTTL is the number of seconds a ListMetrics result is reused
+	// before the namespace is listed again.
+	CacheTTL int64 `toml:"cache_ttl"`
+
+	// ResourceTags lists the tags of the resources named by the dimensions,
+	// e.g. the EC2 instance for InstanceId, that are added to the metrics.
+	ResourceTags []string
+	// ResourceTagsTTL is the number of seconds looked up resource tags are
+	// reused.
+	ResourceTagsTTL int64 `toml:"resource_tags_ttl"`
+
+	// Tags are added to every point of the block.
+	Tags map[string]string
+	// MeasurementName is a template for the measurement names, using
+	// {prefix}, {namespace}, {metric}, {stat} and {region}.
+	MeasurementName string
+	// SnakeCase converts CamelCase metric and namespace names to snake_case
+	// in measurement names.
+	SnakeCase bool
+
+	cache  *metricCache
+	state  *seriesState
+	legacy bool
+	pool   workerPool
+	// multiRegion is set for blocks expanded from Regions, whose series are
+	// told apart by region even in the legacy layout.
+	multiRegion bool
+
+	tagLookups   map[string]TagLookup
+	resourceTags *resourceTagCache
+	// batchUnsupported is set once the endpoint rejects GetMetricData, so
+	// later gathers go straight to GetMetricStatistics.
+	batchUnsupported bool
 }
 
 type CloudWatch struct {
-	Debug   bool
+	Debug bool
+	// EndpointURL overrides the CloudWatch endpoint, e.g. to use a local
+	// CloudWatch-compatible server instead of AWS.
+	EndpointURL string `toml:"endpoint_url"`
+	// StateFile keeps the newest timestamp emitted per series across
+	// restarts.
+	StateFile string
+	// LegacyMeasurements writes every statistic as its own measurement
+	// named prefix_metric_statistic with a single value field.
+	LegacyMeasurements bool
+	// Concurrency is the number of API calls made in parallel across all
+	// metric blocks.
+	Concurrency int
+	// RateLimit is the number of API calls started per second.
+	RateLimit int
+	// ReportAPIUsage writes the API calls made by every gather, per
+	// namespace and region, as the api_usage measurement.
+	ReportAPIUsage bool `toml:"report_api_usage"`
+
 	Metrics []Metric
+	// Alarms reports the state of CloudWatch alarms.
+	Alarms []Alarms
+
+	clients map[clientKey]cloudwatchiface.CloudWatchAPI
+	state   *seriesState
+	// blocks are the configured metric blocks, one per region.
+	blocks  []*Metric
+	pool    workerPool
+	limiter *rateLimiter
+	usage   *usageRecorder
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
 }
 
 func (cw *CloudWatch) Description() string {
 	return "Pull metrics from AWS CloudWatch."
 }
 
+var sampleConfig = `
+  # Print every request and response
+  debug = false
+
+  # Override the CloudWatch endpoint, e.g. for a local mock server
+  # endpoint_url = "http://localhost:4582"
+
+  # Remember the newest datapoint per series across restarts
+  # state_file = "/var/lib/telegraf/cloudwatch.state"
+
+  # Write each statistic as its own measurement with a single value field
+  # legacy_measurements = false
+
+  # Number of API calls made in parallel and started per second
+  # concurrency = 4
+  # rate_limit = 20
+
+  # Write the API calls, throttled calls, errors, latency, datapoints and
+  # estimated billed requests of every gather as cloudwatch_api_usage
+  # report_api_usage = false
+
+  # Specify metrics via an array of tables, one per namespace
+  [[cloudwatch.metrics]]
+    # A single region, or a list of regions to gather the block in
+    region = "us-east-1"
+    # regions = ["us-east-1", "eu-west-1"]
+    namespace = "AWS/ELB"
+
+    # Metrics to request. If left out, every metric of the namespace is
+    # discovered with ListMetrics
+    metric_names = ["Latency", "RequestCount"]
+
+    # Any of Average, Maximum, Minimum, Sum and SampleCount
+    statistics = ["Average", "Sum"]
+    # Percentiles, only available through GetMetricData
+    # extended_statistics = ["p50", "p99"]
+
+    # Period of each datapoint in seconds: a multiple of 60, or 1, 5, 10
+    # or 30 for high-resolution metrics
+    period = 60
+    # Length of the requested window in seconds, at least one period
+    duration = 300
+    # Seconds to stay behind now, so that only complete periods are read
+    # delay = 0
+
+    # Prefix of the measurement names
+    prefix = "elb"
+
+    # Template of the measurement names, using {prefix}, {namespace},
+    # {metric}, {stat} and {region}. With {stat} every statistic is written
+    # as its own measurement with a single value field
+    # measurement_name = "{namespace}_{metric}"
+    # Convert CamelCase names such as CPUUtilization to cpu_utilization
+    # snake_case = false
+
+    # Only request datapoints with this unit. GetMetricData does not report
+    # units, so by default the unit tag is only set from this option
+    # unit = "Seconds"
+
+    # When discovering, only keep metrics with exactly these dimensions and
+    # reuse the ListMetrics result for cache_ttl seconds
+    # dimension_names = ["LoadBalancerName"]
+    # cache_ttl = 300
+
+    # Tags of the resources named by the dimensions (EC2 instances, classic
+    # load balancers, RDS instances) to add to the metrics, looked up every
+    # resource_tags_ttl seconds
+    # resource_tags = ["Name"]
+    # resource_tags_ttl = 3600
+
+    # Credentials, by default the SDK chain (environment, shared
+    # credentials file, EC2 instance role) is used
+    # access_key = ""
+    # secret_key = ""
+    # token = ""
+    # profile = ""
+    # shared_credential_file = ""
+    # use_instance_role = false
+    # role_arn = ""
+    # external_id = ""
+    # role_session_name = ""
+
+    # Tags added to every point of the block
+    # [cloudwatch.metrics.tags]
+    #   environment = "production"
+
+    # Dimensions of the metrics, each a value or a list of values. Values
+    # may be glob patterns such as "*" to match every value
+    [cloudwatch.metrics.dimensions]
+      LoadBalancerName = ["my-load-balancer", "my-other-load-balancer"]
+
+  # Report the state of CloudWatch alarms as the alarm measurement
+  # [[cloudwatch.alarms]]
+  #   region = "us-east-1"
+  #   # regions = ["us-east-1", "eu-west-1"]
+  #   # Only alarms whose name starts with name_prefix
+  #   name_prefix = "prod-"
+  #   # Only alarms in these states, any of OK, ALARM and INSUFFICIENT_DATA
+  #   states = ["ALARM", "INSUFFICIENT_DATA"]
+`
+
 func (cw *CloudWatch) SampleConfig() string {
-	return "ok = true # indicate if everything is fine"
+	return sampleConfig
 }
 
 func (cw *CloudWatch) Gather(acc plugins.Accumulator) error {
+	cw.setup()
+	now := cw.now()
+
+	if cw.state == nil {
+		cw.state = newSeriesState(cw.StateFile)
+		if err := cw.state.load(); err != nil {
+			log.Printf("Error in plugin [cloudwatch]: could not load state "+
+				"file: %s\n", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var errs errorList
+	if cw.blocks == nil {
+		cw.blocks = expandRegions(cw.Metrics)
+	}
 
+	for _, m := range cw.blocks {
+		m := m
+		cw.prepare(m)
+		m.state = cw.state
+		svc := cw.client(m.Region, m.CredentialConfig)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs.add(m.gather(svc, acc, now))
+		}()
+	}
+
+	for i := range cw.Alarms {
+		a := &cw.Alarms[i]
+		for _, region := range a.regions() {
+			region := region
+			svc := cw.client(region, a.CredentialConfig)
+			cw.pool.run(&wg, func() {
+				errs.add(a.gather(svc, acc, region))
+			})
+		}
+	}
+	wg.Wait()
+
+	if err := cw.state.commit(now); err != nil {
+		errs.add(fmt.Errorf("could not save state file: %s", err))
+	}
+	cw.usage.write(acc)
+
+	return errs.err()
+}
+
+// setup initialises the clock and the worker pool shared by all blocks.
+func (cw *CloudWatch) setup() {
 	Debug = cw.Debug
 
-	for _, m := range cw.Metrics {
-		m.PushMetrics(acc)
+	if cw.now == nil {
+		cw.now = time.Now
 	}
 
-	return nil
+	if cw.ReportAPIUsage && cw.usage == nil {
+		cw.usage = newUsageRecorder()
+	}
+
+	if cw.pool == nil {
+		concurrency := cw.Concurrency
+		if concurrency <= 0 {
+			concurrency = defaultConcurrency
+		}
+		cw.pool = make(workerPool, concurrency)
+	}
+}
+
+// prepare hands the plugin-wide settings to a metric block.
+func (cw *CloudWatch) prepare(m *Metric) {
+	m.legacy = cw.LegacyMeasurements
+	m.pool = cw.pool
+	if len(m.ResourceTags) > 0 && m.tagLookups == nil {
+		m.tagLookups = newTagLookups(
+			m.CredentialConfig.RetryingSession(m.Region, "", 0))
+	}
 }
 
 func printDebug(m ...interface{}) {
@@ -76,56 +332,270 @@ func copyDims(dims map[string]string) map[string]string {
 	return dimsCopy
 }
 
-func (m *Metric) PushMetrics(acc plugins.Accumulator) error {
+// statisticFields maps the statistics handled by the plugin to the field
+// names they are written as.
+var statisticFields = map[string]string{
+	"Average":     "average",
+	"Maximum":     "maximum",
+	"Minimum":     "minimum",
+	"Sum":         "sum",
+	"SampleCount": "sample_count",
+}
+
+// statisticField returns the field name used for a statistic. Percentiles keep their name with the dot replaced, so p99.9
+// becomes p99_9.
+func statisticField(statistic string) string {
+	if field, ok := statisticFields[statistic]; ok {
+		return field
+	}
+	return strings.Replace(statistic, ".", "_", -1)
+}
+
+func dimsToTags(dims []*cloudwatch.Dimension) map[string]string {
+	tags := make(map[string]string)
+	for _, d := range dims {
+		tags[*d.Name] = *d.Value
+	}
+	return tags
+}
+
+// queries returns the metrics this block should request statistics for,
+// either built from the configured MetricNames and every combination of the
+// dimension values, or discovered via ListMetrics when no names are given or
+// a dimension value is a pattern.
+func (m *Metric) queries(
+	svc cloudwatchiface.CloudWatchAPI,
+	now time.Time,
+) ([]*cloudwatch.Metric, error) {
+	if len(m.MetricNames) == 0 || m.hasPatterns() {
+		return m.discover(svc, now)
+	}
+
+	var metrics []*cloudwatch.Metric
+	for _, metricName := range m.MetricNames {
+		for _, dims := range expandDimensions(m.Dimensions) {
+			metrics = append(metrics, &cloudwatch.Metric{
+				Namespace:  aws.String(m.Namespace),
+				MetricName: aws.String(metricName),
+				Dimensions: convertDimensions(dims),
+			})
+		}
+	}
+	return metrics, nil
+}
+
+// regions returns the regions the block is gathered in.
+func (m *Metric) regions() []string {
+	if len(m.Regions) > 0 {
+		return m.Regions
+	}
+	return []string{m.Region}
+}
+
+// expandRegions returns a copy of each metric block for every region it is
+// gathered in, so each region keeps its own discovery and tag caches.
+func expandRegions(metrics []Metric) []*Metric {
+	var blocks []*Metric
+	for i := range metrics {
+		for _, region := range metrics[i].regions() {
+			m := metrics[i]
+			m.Region = region
+			m.Regions = nil
+			m.multiRegion = len(metrics[i].Regions) > 0
+			blocks = append(blocks, &m)
+		}
+	}
+	return blocks
+}
+
+// clientKey identifies the client for a region, endpoint and set of
+// credentials.
+type clientKey struct {
+	region   string
+	endpoint string
+	creds    internalaws.CredentialConfig
+}
+
+// client returns the CloudWatch client for a region and set of credentials,
+// creating it on first use. Clients are shared by blocks with the same region
+// and credentials and are kept for the lifetime of the plugin. All clients
+// share one rate limit.
+func (cw *CloudWatch) client(
+	region string,
+	creds internalaws.CredentialConfig,
+) cloudwatchiface.CloudWatchAPI {
+	key := clientKey{region, cw.EndpointURL, creds}
+	if svc, ok := cw.clients[key]; ok {
+		return svc
+	}
+
+	// The throttled client retries on its own
+	sess := creds.Session(region, cw.EndpointURL)
+	if cw.limiter == nil {
+		rateLimit := cw.RateLimit
+		if rateLimit <= 0 {
+			rateLimit = defaultRateLimit
+		}
+		cw.limiter = newRateLimiter(rateLimit)
+	}
+	svc := newThrottledClient(
+		&metricDataClient{cloudwatch.New(sess)}, cw.limiter)
+	svc.usage = cw.usage
+	svc.region = region
+
+	if cw.clients == nil {
+		cw.clients = make(map[clientKey]cloudwatchiface.CloudWatchAPI)
+	}
+	cw.clients[key] = svc
+	return svc
+}
+
+// gather collects all metrics of the block over its window at time now.
+func (m *Metric) gather(
+	svc cloudwatchiface.CloudWatchAPI,
+	acc plugins.Accumulator,
+	now time.Time,
+) error {
+	start, end := m.window(now)
+	return m.gatherRange(svc, acc, now, start, end)
+}
+
+// gatherRange collects all metrics of the block from start to end. Clients
+// that support GetMetricData are queried in batches, everything else falls
+// back to one GetMetricStatistics call per metric. API calls run on the
+// block's worker pool; a failing call does not stop the others and all
+// errors are returned together.
+func (m *Metric) gatherRange(
+	svc cloudwatchiface.CloudWatchAPI,
+	acc plugins.Accumulator,
+	now, start, end time.Time,
+) error {
+	var metrics []*cloudwatch.Metric
+	var err error
+	var wg sync.WaitGroup
+	m.pool.run(&wg, func() {
+		metrics, err = m.queries(svc, now)
+	})
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	if batcher, ok := svc.(metricDataAPI); ok && !m.batchUnsupported {
+		err := m.gatherBatched(batcher, metrics, start, end, acc)
+		if !isBatchUnsupported(err) {
+			return err
+		}
+		printDebug("GetMetricData not supported, falling back: ", err)
+		m.batchUnsupported = true
+	}
+
+	if err := m.gatherEach(svc, metrics, start, end, acc); err != nil {
+		return err
+	}
+	if len(m.ExtendedStatistics) > 0 {
+		return &unsupportedStatisticsError{m.ExtendedStatistics}
+	}
+	return nil
+}
+
+// unsupportedStatisticsError reports the extended statistics left out because
+// the endpoint does not support GetMetricData.
+type unsupportedStatisticsError struct {
+	statistics []string
+}
+
+func (e *unsupportedStatisticsError) Error() string {
+	return fmt.Sprintf("cannot collect extended statistics %s: the endpoint "+
+		"does not support GetMetricData", strings.Join(e.statistics, ", "))
+}
 
-	sess := session.New(&aws.Config{Region: aws.String(m.Region)})
-	svc := cloudwatch.New(sess)
+// window returns the time range to request at time now. The end is moved
+// back by Delay and then down to a multiple of Period, so every requested
+// period is complete and windows line up across agents.
+func (m *Metric) window(now time.Time) (time.Time, time.Time) {
+	period := time.Duration(m.Period) * time.Second
+	end := now.Add(-time.Duration(m.Delay) * time.Second)
+	if period > 0 {
+		end = end.Truncate(period)
+	}
+	start := end.Add(-time.Duration(m.Duration) * time.Second)
+	if period > 0 {
+		start = start.Truncate(period)
+	}
+	return start, end
+}
 
+// gatherEach issues one GetMetricStatistics call per metric.
+func (m *Metric) gatherEach(
+	svc cloudwatchiface.CloudWatchAPI,
+	metrics []*cloudwatch.Metric,
+	start, end time.Time,
+	acc plugins.Accumulator,
+) error {
+	ps := newPointSet()
+	var wg sync.WaitGroup
+	var errs errorList
+	for _, metric := range metrics {
+		metric := metric
+		m.pool.run(&wg, func() {
+			errs.add(m.gatherStatistics(svc, metric, start, end, ps))
+		})
+	}
+	wg.Wait()
+
+	errs.add(m.write(acc, ps))
+	return errs.err()
+}
+
+// gatherStatistics requests the statistics of a single metric and adds the
+// datapoints to ps.
+func (m *Metric) gatherStatistics(
+	svc cloudwatchiface.CloudWatchAPI,
+	metric *cloudwatch.Metric,
+	start, end time.Time,
+	ps *pointSet,
+) error {
 	params := &cloudwatch.GetMetricStatisticsInput{
-		EndTime:    aws.Time(time.Now()),
+		EndTime:    aws.Time(end),
 		Namespace:  aws.String(m.Namespace),
+		MetricName: metric.MetricName,
+		Dimensions: metric.Dimensions,
 		Period:     aws.Int64(m.Period),
-		StartTime:  aws.Time(time.Now().Add(-time.Duration(m.Duration) * time.Second)),
+		StartTime:  aws.Time(start),
 		Statistics: aws.StringSlice(m.Statistics),
-		Dimensions: convertDimensions(m.Dimensions),
-		// Unit:       aws.String(m.Unit),
+	}
+	if m.Unit != "" {
+		params.Unit = aws.String(m.Unit)
 	}
 
 	printDebug(params)
 
-	for _, metricName := range m.MetricNames {
-
-		params.MetricName = aws.String(metricName)
-		printDebug("requesting metric: ", metricName)
+	resp, err := svc.GetMetricStatistics(params)
+	if err != nil {
+		return fmt.Errorf("%s: %s", *metric.MetricName, err)
+	}
 
-		resp, err := svc.GetMetricStatistics(params)
+	printDebug(resp)
 
-		if err != nil {
-			fmt.Println(err.Error())
-			return err
+	tags := dimsToTags(metric.Dimensions)
+	for _, d := range resp.Datapoints {
+		unit := aws.StringValue(d.Unit)
+		if d.Average != nil {
+			m.add(ps, *resp.Label, "Average", *d.Average, tags, unit, *d.Timestamp)
 		}
-
-		printDebug(resp)
-
-		for _, d := range resp.Datapoints {
-			if d.Average != nil {
-				label := strings.Join([]string{m.Prefix, *resp.Label, "average"}, "_")
-				acc.Add(label, *d.Average, copyDims(m.Dimensions), *d.Timestamp)
-			}		
-			if d.Maximum != nil {
-				label := strings.Join([]string{m.Prefix, *resp.Label, "maximum"}, "_")
-				acc.Add(label, *d.Maximum, copyDims(m.Dimensions), *d.Timestamp)
-			}
-			if d.Minimum != nil {
-				label := strings.Join([]string{m.Prefix, *resp.Label, "minimum"}, "_")
-				acc.Add(label, *d.Minimum, copyDims(m.Dimensions), *d.Timestamp)
-			}
-			if d.Sum != nil {
-				label := strings.Join([]string{m.Prefix, *resp.Label, "sum"}, "_")
-				acc.Add(label, *d.Sum, copyDims(m.Dimensions), *d.Timestamp)
-			}
+		if d.Maximum != nil {
+			m.add(ps, *resp.Label, "Maximum", *d.Maximum, tags, unit, *d.Timestamp)
+		}
+		if d.Minimum != nil {
+			m.add(ps, *resp.Label, "Minimum", *d.Minimum, tags, unit, *d.Timestamp)
+		}
+		if d.Sum != nil {
+			m.add(ps, *resp.Label, "Sum", *d.Sum, tags, unit, *d.Timestamp)
+		}
+		if d.SampleCount != nil {
+			m.add(ps, *resp.Label, "SampleCount", *d.SampleCount, tags, unit, *d.Timestamp)
 		}
-
 	}
 
 	return nil
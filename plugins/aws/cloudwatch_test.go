@@ -0,0 +1,833 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/naoina/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockCloudWatchClient implements the parts of cloudwatchiface.CloudWatchAPI
+// used by the plugin. Calling any other method panics.
+type mockCloudWatchClient struct {
+	cloudwatchiface.CloudWatchAPI
+
+	metrics   []*cloudwatch.Metric
+	listCalls int
+	requests  []*cloudwatch.GetMetricStatisticsInput
+	// lag moves the returned datapoint back in time
+	lag time.Duration
+}
+
+func (c *mockCloudWatchClient) ListMetricsPages(
+	params *cloudwatch.ListMetricsInput,
+	fn func(*cloudwatch.ListMetricsOutput, bool) bool,
+) error {
+	c.listCalls++
+	// Hand the metrics out one page at a time to exercise paging
+	for i, metric := range c.metrics {
+		page := &cloudwatch.ListMetricsOutput{
+			Metrics: []*cloudwatch.Metric{metric},
+		}
+		if !fn(page, i == len(c.metrics)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *mockCloudWatchClient) GetMetricStatistics(
+	params *cloudwatch.GetMetricStatisticsInput,
+) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	request := *params
+	c.requests = append(c.requests, &request)
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Label: params.MetricName,
+		Datapoints: []*cloudwatch.Datapoint{
+			{
+				Average:   aws.Float64(1.5),
+				Timestamp: aws.Time(params.EndTime.Add(-time.Minute - c.lag)),
+			},
+		},
+	}, nil
+}
+
+func newMetric(name string, dims map[string]string) *cloudwatch.Metric {
+	return &cloudwatch.Metric{
+		Namespace:  aws.String("AWS/EC2"),
+		MetricName: aws.String(name),
+		Dimensions: convertDimensions(dims),
+	}
+}
+
+func fakeEC2Client() *mockCloudWatchClient {
+	return &mockCloudWatchClient{
+		metrics: []*cloudwatch.Metric{
+			newMetric("CPUUtilization", map[string]string{"InstanceId": "i-abc"}),
+			newMetric("CPUUtilization", map[string]string{"InstanceId": "i-def"}),
+			newMetric("CPUUtilization", map[string]string{"InstanceId": "x-123"}),
+			newMetric("CPUUtilization", map[string]string{"ImageId": "ami-1"}),
+			newMetric("CPUUtilization", map[string]string{
+				"InstanceId":   "i-abc",
+				"InstanceType": "t2.micro",
+			}),
+		},
+	}
+}
+
+func TestDiscoverFiltersDimensions(t *testing.T) {
+	m := &Metric{
+		Namespace:      "AWS/EC2",
+		DimensionNames: []string{"InstanceId"},
+		Dimensions:     map[string]DimensionValues{"InstanceId": {"i-*"}},
+	}
+
+	metrics, err := m.discover(fakeEC2Client(), time.Now())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "i-abc", *metrics[0].Dimensions[0].Value)
+	assert.Equal(t, "i-def", *metrics[1].Dimensions[0].Value)
+}
+
+func TestDiscoverBadPattern(t *testing.T) {
+	m := &Metric{
+		Namespace:  "AWS/EC2",
+		Dimensions: map[string]DimensionValues{"InstanceId": {"["}},
+	}
+
+	_, err := m.discover(fakeEC2Client(), time.Now())
+	require.Error(t, err)
+}
+
+func TestDiscoverCache(t *testing.T) {
+	svc := fakeEC2Client()
+	m := &Metric{
+		Namespace: "AWS/EC2",
+		CacheTTL:  60,
+	}
+
+	now := time.Now()
+	metrics, err := m.discover(svc, now)
+	require.NoError(t, err)
+	assert.Len(t, metrics, 5)
+	assert.Equal(t, 1, svc.listCalls)
+
+	_, err = m.discover(svc, now.Add(59*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, svc.listCalls)
+
+	_, err = m.discover(svc, now.Add(60*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 2, svc.listCalls)
+}
+
+func TestGatherDiscoveredMetrics(t *testing.T) {
+	m := &Metric{
+		Namespace:      "AWS/EC2",
+		Prefix:         "ec2",
+		Statistics:     []string{"Average"},
+		Period:         60,
+		Duration:       120,
+		DimensionNames: []string{"InstanceId"},
+		Dimensions:     map[string]DimensionValues{"InstanceId": {"i-*"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(fakeEC2Client(), &acc, time.Now()))
+
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "i-abc", "namespace": "AWS/EC2"}))
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "i-def", "namespace": "AWS/EC2"}))
+}
+
+func TestGatherStaticMetrics(t *testing.T) {
+	svc := fakeEC2Client()
+	m := &Metric{
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization", "NetworkIn"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    120,
+		Dimensions:  map[string]DimensionValues{"InstanceId": {"i-abc"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	assert.Equal(t, 0, svc.listCalls)
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_NetworkIn",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "i-abc", "namespace": "AWS/EC2"}))
+}
+
+// mockMetricDataClient additionally serves GetMetricData, returning results
+// split over two pages.
+type mockMetricDataClient struct {
+	mockCloudWatchClient
+
+	dataCalls   int
+	unsupported bool
+	queries     []*metricDataQuery
+}
+
+func (c *mockMetricDataClient) GetMetricData(
+	params *getMetricDataInput,
+) (*getMetricDataOutput, error) {
+	c.dataCalls++
+	c.queries = append(c.queries, params.MetricDataQueries...)
+	if c.unsupported {
+		return nil, awserr.New("InvalidAction", "unknown action", nil)
+	}
+
+	var results []*metricDataResult
+	for _, q := range params.MetricDataQueries {
+		results = append(results, &metricDataResult{
+			Id: q.Id,
+			Timestamps: []*string{
+				aws.String(params.EndTime.Add(-time.Minute).UTC().Format(iso8601)),
+			},
+			Values: []*float64{aws.Float64(2.5)},
+		})
+	}
+
+	if params.NextToken == nil {
+		return &getMetricDataOutput{
+			MetricDataResults: results[:1],
+			NextToken:         aws.String("page2"),
+		}, nil
+	}
+	return &getMetricDataOutput{MetricDataResults: results[1:]}, nil
+}
+
+func TestGatherBatched(t *testing.T) {
+	svc := &mockMetricDataClient{mockCloudWatchClient: *fakeEC2Client()}
+	m := &Metric{
+		Namespace:      "AWS/EC2",
+		Prefix:         "ec2",
+		Statistics:     []string{"Average", "Maximum"},
+		Period:         60,
+		Duration:       120,
+		DimensionNames: []string{"InstanceId"},
+		Dimensions:     map[string]DimensionValues{"InstanceId": {"i-*"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	assert.Equal(t, 2, svc.dataCalls)
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 2.5, "maximum": 2.5},
+		map[string]string{"InstanceId": "i-abc", "namespace": "AWS/EC2"}))
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 2.5, "maximum": 2.5},
+		map[string]string{"InstanceId": "i-def", "namespace": "AWS/EC2"}))
+}
+
+func TestGatherBatchedUnit(t *testing.T) {
+	m := &Metric{
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    60,
+		Dimensions:  map[string]DimensionValues{"InstanceId": {"i-abc"}},
+	}
+
+	// GetMetricData does not report units, so no unit tag is set...
+	svc := &mockMetricDataClient{}
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 2.5},
+		map[string]string{"InstanceId": "i-abc", "namespace": "AWS/EC2"}))
+
+	// ...unless the block configures one, which also filters the query
+	m.Unit = "Percent"
+	svc = &mockMetricDataClient{}
+	acc = testutil.Accumulator{}
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+	require.NotEmpty(t, svc.queries)
+	for _, q := range svc.queries {
+		assert.Equal(t, "Percent", aws.StringValue(q.MetricStat.Unit))
+	}
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 2.5},
+		map[string]string{
+			"InstanceId": "i-abc",
+			"namespace":  "AWS/EC2",
+			"unit":       "Percent",
+		}))
+}
+
+func TestGatherBatchedSplitsQueries(t *testing.T) {
+	svc := &mockMetricDataClient{}
+	for i := 0; i < maxMetricDataQueries+1; i++ {
+		svc.metrics = append(svc.metrics, newMetric("CPUUtilization",
+			map[string]string{"InstanceId": fmt.Sprintf("i-%d", i)}))
+	}
+	m := &Metric{
+		Namespace:  "AWS/EC2",
+		Statistics: []string{"Average"},
+		Period:     60,
+		Duration:   60,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	// Two batches, each read in two pages
+	assert.Equal(t, 4, svc.dataCalls)
+	assert.Len(t, acc.Points, maxMetricDataQueries+1)
+}
+
+func TestGatherBatchedFallback(t *testing.T) {
+	svc := &mockMetricDataClient{
+		mockCloudWatchClient: *fakeEC2Client(),
+		unsupported:          true,
+	}
+	m := &Metric{
+		Namespace:      "AWS/EC2",
+		Prefix:         "ec2",
+		Statistics:     []string{"Average"},
+		Period:         60,
+		Duration:       120,
+		DimensionNames: []string{"InstanceId"},
+		Dimensions:     map[string]DimensionValues{"InstanceId": {"i-*"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	// GetMetricData is only tried once
+	assert.Equal(t, 1, svc.dataCalls)
+	assert.Len(t, acc.Points, 4)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "i-abc", "namespace": "AWS/EC2"}))
+
+	// Percentiles cannot be collected without GetMetricData
+	m.ExtendedStatistics = []string{"p99"}
+	acc.Points = nil
+	err := m.gather(svc, &acc, time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot collect extended statistics p99")
+	assert.Len(t, acc.Points, 2)
+}
+
+const getMetricDataResponse = `<GetMetricDataResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <GetMetricDataResult>
+    <MetricDataResults>
+      <member>
+        <Id>q0</Id>
+        <Label>CPUUtilization</Label>
+        <StatusCode>Complete</StatusCode>
+        <Timestamps>
+          <member>2015-11-20T10:00:00Z</member>
+          <member>2015-11-20T10:01:00Z</member>
+        </Timestamps>
+        <Values>
+          <member>12.5</member>
+          <member>13</member>
+        </Values>
+      </member>
+    </MetricDataResults>
+  </GetMetricDataResult>
+</GetMetricDataResponse>`
+
+func TestMetricDataClient(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, getMetricDataResponse)
+	}))
+	defer ts.Close()
+
+	sess := session.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(ts.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	svc := &metricDataClient{cloudwatch.New(sess)}
+
+	m := &Metric{
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    120,
+		Dimensions:  map[string]DimensionValues{"InstanceId": {"i-abc"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	assert.Equal(t, "GetMetricData", form.Get("Action"))
+	assert.Equal(t, "CPUUtilization",
+		form.Get("MetricDataQueries.member.1.MetricStat.Metric.MetricName"))
+	assert.Equal(t, "InstanceId",
+		form.Get("MetricDataQueries.member.1.MetricStat.Metric.Dimensions.member.1.Name"))
+	assert.Equal(t, "Average", form.Get("MetricDataQueries.member.1.MetricStat.Stat"))
+
+	require.Len(t, acc.Points, 2)
+	assert.Equal(t, 13.0, acc.Points[1].Fields["average"])
+	assert.Equal(t, time.Date(2015, 11, 20, 10, 1, 0, 0, time.UTC),
+		acc.Points[1].Time)
+}
+
+func TestClientCache(t *testing.T) {
+	cw := &CloudWatch{}
+	a := &Metric{Region: "us-east-1"}
+	b := &Metric{Region: "us-east-1", Namespace: "AWS/ELB"}
+	c := &Metric{Region: "us-east-1"}
+	c.Profile = "other"
+	d := &Metric{Region: "eu-west-1"}
+
+	client := func(m *Metric) cloudwatchiface.CloudWatchAPI {
+		return cw.client(m.Region, m.CredentialConfig)
+	}
+	assert.True(t, client(a) == client(b))
+	assert.False(t, client(a) == client(c))
+	assert.False(t, client(a) == client(d))
+	assert.Len(t, cw.clients, 3)
+}
+
+const listMetricsResponse = `<ListMetricsResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <ListMetricsResult>
+    <Metrics>
+      <member>
+        <Namespace>AWS/EC2</Namespace>
+        <MetricName>CPUUtilization</MetricName>
+        <Dimensions>
+          <member>
+            <Name>InstanceId</Name>
+            <Value>i-abc</Value>
+          </member>
+        </Dimensions>
+      </member>
+    </Metrics>
+  </ListMetricsResult>
+</ListMetricsResponse>`
+
+// newCloudWatchServer returns a test server answering the CloudWatch query
+// API actions used by the plugin, counting the calls made per action.
+func newCloudWatchServer(calls map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		action := r.PostForm.Get("Action")
+		calls[action]++
+		switch action {
+		case "ListMetrics":
+			fmt.Fprint(w, listMetricsResponse)
+		case "GetMetricData":
+			fmt.Fprint(w, getMetricDataResponse)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestGatherEndpointURL(t *testing.T) {
+	calls := make(map[string]int)
+	ts := newCloudWatchServer(calls)
+	defer ts.Close()
+
+	m := Metric{
+		Region:     "us-east-1",
+		Namespace:  "AWS/EC2",
+		Prefix:     "ec2",
+		Statistics: []string{"Average"},
+		Period:     60,
+		Duration:   120,
+	}
+	m.AccessKey = "AKID"
+	m.SecretKey = "SECRET"
+	cw := &CloudWatch{EndpointURL: ts.URL, Metrics: []Metric{m}}
+
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Gather(&acc))
+	require.NoError(t, cw.Gather(&acc))
+
+	assert.Equal(t, 1, calls["ListMetrics"])
+	assert.Equal(t, 2, calls["GetMetricData"])
+	assert.Len(t, cw.clients, 1)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 12.5},
+		map[string]string{
+			"InstanceId": "i-abc",
+			"namespace":  "AWS/EC2",
+			"region":     "us-east-1",
+		}))
+}
+
+func TestWindow(t *testing.T) {
+	now := time.Date(2015, 11, 20, 10, 7, 42, 0, time.UTC)
+	var tests = []struct {
+		period, duration, delay int64
+		start, end              time.Time
+	}{
+		{60, 300, 0,
+			time.Date(2015, 11, 20, 10, 2, 0, 0, time.UTC),
+			time.Date(2015, 11, 20, 10, 7, 0, 0, time.UTC)},
+		{60, 300, 120,
+			time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC),
+			time.Date(2015, 11, 20, 10, 5, 0, 0, time.UTC)},
+		{300, 600, 60,
+			time.Date(2015, 11, 20, 9, 55, 0, 0, time.UTC),
+			time.Date(2015, 11, 20, 10, 5, 0, 0, time.UTC)},
+		{300, 450, 0,
+			time.Date(2015, 11, 20, 9, 55, 0, 0, time.UTC),
+			time.Date(2015, 11, 20, 10, 5, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		m := &Metric{Period: test.period, Duration: test.duration, Delay: test.delay}
+		start, end := m.window(now)
+		assert.Equal(t, test.start, start)
+		assert.Equal(t, test.end, end)
+	}
+}
+
+func TestGatherClock(t *testing.T) {
+	svc := fakeEC2Client()
+	cw := &CloudWatch{
+		Metrics: []Metric{{
+			Namespace:   "AWS/EC2",
+			MetricNames: []string{"CPUUtilization"},
+			Statistics:  []string{"Average"},
+			Period:      60,
+			Duration:    180,
+			Delay:       60,
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{{}: svc},
+		now: func() time.Time {
+			return time.Date(2015, 11, 20, 10, 7, 42, 0, time.UTC)
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Gather(&acc))
+
+	require.Len(t, svc.requests, 1)
+	assert.Equal(t, time.Date(2015, 11, 20, 10, 3, 0, 0, time.UTC),
+		*svc.requests[0].StartTime)
+	assert.Equal(t, time.Date(2015, 11, 20, 10, 6, 0, 0, time.UTC),
+		*svc.requests[0].EndTime)
+}
+
+func TestStatisticField(t *testing.T) {
+	assert.Equal(t, "average", statisticField("Average"))
+	assert.Equal(t, "sample_count", statisticField("SampleCount"))
+	assert.Equal(t, "p50", statisticField("p50"))
+	assert.Equal(t, "p99_9", statisticField("p99.9"))
+}
+
+func TestGatherSampleCount(t *testing.T) {
+	svc := &sampleCountClient{}
+	m := &Metric{
+		Namespace:   "AWS/ELB",
+		Prefix:      "elb",
+		MetricNames: []string{"Latency"},
+		Statistics:  []string{"SampleCount"},
+		Period:      60,
+		Duration:    60,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	assert.True(t, acc.CheckFieldsValue("elb_Latency",
+		map[string]interface{}{"sample_count": 42.0}))
+}
+
+// sampleCountClient answers GetMetricStatistics with a SampleCount datapoint.
+type sampleCountClient struct {
+	mockCloudWatchClient
+}
+
+func (c *sampleCountClient) GetMetricStatistics(
+	params *cloudwatch.GetMetricStatisticsInput,
+) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Label: params.MetricName,
+		Datapoints: []*cloudwatch.Datapoint{
+			{
+				SampleCount: aws.Float64(42),
+				Timestamp:   aws.Time(params.EndTime.Add(-time.Minute)),
+				Unit:        aws.String("Count"),
+			},
+		},
+	}, nil
+}
+
+func TestGatherBatchedExtendedStatistics(t *testing.T) {
+	svc := &mockMetricDataClient{}
+	m := &Metric{
+		Namespace:          "AWS/ELB",
+		Prefix:             "elb",
+		MetricNames:        []string{"Latency"},
+		Statistics:         []string{"SampleCount"},
+		ExtendedStatistics: []string{"p50", "p99.9"},
+		Period:             60,
+		Duration:           60,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	require.Len(t, acc.Points, 1)
+	assert.True(t, acc.CheckFieldsValue("elb_Latency", map[string]interface{}{
+		"sample_count": 2.5,
+		"p50":          2.5,
+		"p99_9":        2.5,
+	}))
+}
+
+func TestGatherUnit(t *testing.T) {
+	svc := &sampleCountClient{}
+	m := &Metric{
+		Region:      "us-east-1",
+		Namespace:   "AWS/ELB",
+		Prefix:      "elb",
+		MetricNames: []string{"Latency"},
+		Statistics:  []string{"SampleCount"},
+		Period:      60,
+		Duration:    60,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	assert.True(t, acc.CheckTaggedFieldsValue("elb_Latency",
+		map[string]interface{}{"sample_count": 42.0},
+		map[string]string{
+			"namespace": "AWS/ELB",
+			"region":    "us-east-1",
+			"unit":      "Count",
+		}))
+}
+
+func TestGatherLegacyMeasurements(t *testing.T) {
+	svc := &mockMetricDataClient{}
+	m := &Metric{
+		Namespace:   "AWS/ELB",
+		Prefix:      "elb",
+		MetricNames: []string{"Latency"},
+		Statistics:  []string{"Average", "SampleCount"},
+		Period:      60,
+		Duration:    60,
+		legacy:      true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckValue("elb_Latency_average", 2.5))
+	assert.True(t, acc.CheckValue("elb_Latency_sample_count", 2.5))
+}
+
+func TestDimensionValuesTOML(t *testing.T) {
+	var config struct {
+		Dimensions map[string]DimensionValues
+	}
+	require.NoError(t, toml.Unmarshal([]byte(`
+[dimensions]
+  InstanceId = ["i-abc", "i-def"]
+  VolumeId = "vol-*"
+`), &config))
+
+	assert.Equal(t, map[string]DimensionValues{
+		"InstanceId": {"i-abc", "i-def"},
+		"VolumeId":   {"vol-*"},
+	}, config.Dimensions)
+}
+
+func TestExpandDimensions(t *testing.T) {
+	assert.Equal(t, []map[string]string{{}}, expandDimensions(nil))
+	assert.Equal(t, []map[string]string{
+		{"InstanceId": "i-abc", "VolumeId": "vol-1"},
+		{"InstanceId": "i-abc", "VolumeId": "vol-2"},
+		{"InstanceId": "i-def", "VolumeId": "vol-1"},
+		{"InstanceId": "i-def", "VolumeId": "vol-2"},
+	}, expandDimensions(map[string]DimensionValues{
+		"VolumeId":   {"vol-1", "vol-2"},
+		"InstanceId": {"i-abc", "i-def"},
+	}))
+}
+
+func TestGatherDimensionLists(t *testing.T) {
+	svc := fakeEC2Client()
+	m := &Metric{
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    120,
+		Dimensions: map[string]DimensionValues{
+			"InstanceId": {"i-abc", "i-def"},
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	assert.Equal(t, 0, svc.listCalls)
+	require.Len(t, svc.requests, 2)
+	assert.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "i-def", "namespace": "AWS/EC2"}))
+}
+
+func TestGatherDimensionWildcard(t *testing.T) {
+	svc := fakeEC2Client()
+	m := &Metric{
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    120,
+		Dimensions:  map[string]DimensionValues{"InstanceId": {"*"}},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(svc, &acc, time.Now()))
+
+	// Metrics with other dimensions than InstanceId are not matched
+	assert.Equal(t, 1, svc.listCalls)
+	require.Len(t, acc.Points, 3)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "x-123", "namespace": "AWS/EC2"}))
+}
+
+func TestGatherRegions(t *testing.T) {
+	east, west := fakeEC2Client(), fakeEC2Client()
+	cw := &CloudWatch{
+		Metrics: []Metric{{
+			Regions:     []string{"us-east-1", "eu-west-1"},
+			Namespace:   "AWS/EC2",
+			Prefix:      "ec2",
+			MetricNames: []string{"CPUUtilization"},
+			Statistics:  []string{"Average"},
+			Period:      60,
+			Duration:    60,
+			Dimensions:  map[string]DimensionValues{"InstanceId": {"i-abc"}},
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: east,
+			{region: "eu-west-1"}: west,
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Gather(&acc))
+
+	assert.Len(t, east.requests, 1)
+	assert.Len(t, west.requests, 1)
+	require.Len(t, acc.Points, 2)
+	for _, region := range []string{"us-east-1", "eu-west-1"} {
+		assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+			map[string]interface{}{"average": 1.5},
+			map[string]string{
+				"InstanceId": "i-abc",
+				"namespace":  "AWS/EC2",
+				"region":     region,
+			}), region)
+	}
+}
+
+func TestGatherSameSeriesInSeveralBlocks(t *testing.T) {
+	block := Metric{
+		Region:      "us-east-1",
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    60,
+		Dimensions:  map[string]DimensionValues{"InstanceId": {"i-abc"}},
+	}
+	inWest := block
+	inWest.Region = "eu-west-1"
+	withRole := block
+	withRole.RoleArn = "arn:aws:iam::123456789012:role/telegraf"
+	withRole.Region = "eu-west-1"
+
+	// The same series is returned from other regions and accounts, lagging
+	// behind the first block
+	east, west, role := fakeEC2Client(), fakeEC2Client(), fakeEC2Client()
+	west.lag = 3 * time.Minute
+	role.lag = 3 * time.Minute
+	now := time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC)
+	cw := &CloudWatch{
+		Metrics: []Metric{block, inWest, withRole},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: east,
+			{region: "eu-west-1"}: west,
+			{region: "eu-west-1", creds: withRole.CredentialConfig}: role,
+		},
+		now: func() time.Time { return now },
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Gather(&acc))
+	require.Len(t, acc.Points, 3)
+
+	// Catching up, the lagging blocks still emit their newer datapoints
+	west.lag = 2 * time.Minute
+	role.lag = 2 * time.Minute
+	acc.Points = nil
+	require.NoError(t, cw.Gather(&acc))
+	assert.Len(t, acc.Points, 2)
+}
+
+func TestGatherStateFileError(t *testing.T) {
+	cw := &CloudWatch{
+		StateFile: "/nonexistent/cloudwatch.state",
+		Metrics: []Metric{{
+			Region:      "us-east-1",
+			Namespace:   "AWS/EC2",
+			MetricNames: []string{"CPUUtilization"},
+			Statistics:  []string{"Average"},
+			Period:      60,
+			Duration:    60,
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: fakeEC2Client(),
+		},
+	}
+
+	// The points are written, and the state that could not be saved reported
+	var acc testutil.Accumulator
+	err := cw.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not save state file")
+	assert.Len(t, acc.Points, 1)
+}
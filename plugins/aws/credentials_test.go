@@ -0,0 +1,55 @@
+package aws
+
+import (
+	"testing"
+
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/naoina/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const credentialsConfig = `
+[[metrics]]
+  region = "us-east-1"
+  namespace = "AWS/EC2"
+  access_key = "AKID"
+  secret_key = "SECRET"
+  token = "TOKEN"
+
+[[metrics]]
+  region = "eu-west-1"
+  namespace = "AWS/EC2"
+  profile = "production"
+  shared_credential_file = "/etc/telegraf/aws_credentials"
+  role_arn = "arn:aws:iam::123456789012:role/telegraf"
+  external_id = "tele"
+  role_session_name = "telegraf-eu"
+
+[[metrics]]
+  region = "us-west-2"
+  namespace = "AWS/EC2"
+  use_instance_role = true
+`
+
+func TestCredentialConfigParse(t *testing.T) {
+	var cw CloudWatch
+	require.NoError(t, toml.Unmarshal([]byte(credentialsConfig), &cw))
+	require.Len(t, cw.Metrics, 3)
+
+	assert.Equal(t, internalaws.CredentialConfig{
+		AccessKey: "AKID",
+		SecretKey: "SECRET",
+		Token:     "TOKEN",
+	}, cw.Metrics[0].CredentialConfig)
+
+	assert.Equal(t, internalaws.CredentialConfig{
+		Profile:              "production",
+		SharedCredentialFile: "/etc/telegraf/aws_credentials",
+		RoleArn:              "arn:aws:iam::123456789012:role/telegraf",
+		ExternalId:           "tele",
+		RoleSessionName:      "telegraf-eu",
+	}, cw.Metrics[1].CredentialConfig)
+
+	assert.True(t, cw.Metrics[2].UseInstanceRole)
+}
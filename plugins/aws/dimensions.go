@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/naoina/toml"
+)
+
+// DimensionValues holds the values configured for a dimension. In the
+// config it is either a single string or a list of strings; a value may be
+// a glob pattern such as "*" to match every value of the dimension.
+type DimensionValues []string
+
+// UnmarshalTOML accepts a string or a list of strings.
+func (d *DimensionValues) UnmarshalTOML(data []byte) error {
+	var list struct{ V []string }
+	if err := toml.Unmarshal(append([]byte("v = "), data...), &list); err == nil {
+		*d = list.V
+		return nil
+	}
+
+	var single struct{ V string }
+	if err := toml.Unmarshal(append([]byte("v = "), data...), &single); err != nil {
+		return err
+	}
+	*d = DimensionValues{single.V}
+	return nil
+}
+
+// isPattern returns true if value is a glob pattern rather than a literal
+// dimension value.
+func isPattern(value string) bool {
+	return strings.ContainsAny(value, `*?[\`)
+}
+
+// hasPatterns returns true if any configured dimension value is a glob
+// pattern, so the matching metrics have to be discovered.
+func (m *Metric) hasPatterns() bool {
+	for _, values := range m.Dimensions {
+		for _, value := range values {
+			if isPattern(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandDimensions returns every combination of the configured dimension
+// values, e.g. two instance ids and two volume ids give four sets of
+// dimensions. Combinations are ordered by dimension name and value order.
+func expandDimensions(dims map[string]DimensionValues) []map[string]string {
+	names := make([]string, 0, len(dims))
+	for name := range dims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combinations := []map[string]string{{}}
+	for _, name := range names {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range dims[name] {
+				expanded := copyDims(combination)
+				expanded[name] = value
+				next = append(next, expanded)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
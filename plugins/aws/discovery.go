@@ -0,0 +1,160 @@
+package aws
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// defaultCacheTTL is used when a discovering Metric block sets no CacheTTL.
+const defaultCacheTTL = 5 * time.Minute
+
+// metricCache holds the result of listing a namespace so that ListMetrics
+// is not called on every gather.
+type metricCache struct {
+	metrics []*cloudwatch.Metric
+	fetched time.Time
+	ttl     time.Duration
+}
+
+// isValid returns true if the cached metrics are still fresh at time now.
+func (c *metricCache) isValid(now time.Time) bool {
+	return c != nil && now.Before(c.fetched.Add(c.ttl))
+}
+
+// discover returns every metric in the namespace that matches the configured
+// dimension names and dimension value patterns. Results are cached for
+// CacheTTL seconds.
+func (m *Metric) discover(
+	svc cloudwatchiface.CloudWatchAPI,
+	now time.Time,
+) ([]*cloudwatch.Metric, error) {
+	if m.cache.isValid(now) {
+		return m.cache.metrics, nil
+	}
+
+	params := &cloudwatch.ListMetricsInput{
+		Namespace: aws.String(m.Namespace),
+	}
+	if len(m.MetricNames) == 1 {
+		params.MetricName = aws.String(m.MetricNames[0])
+	}
+	for _, name := range m.dimensionNames() {
+		params.Dimensions = append(params.Dimensions,
+			&cloudwatch.DimensionFilter{Name: aws.String(name)})
+	}
+
+	printDebug("listing metrics: ", params)
+
+	var metrics []*cloudwatch.Metric
+	var matchErr error
+	err := svc.ListMetricsPages(params,
+		func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+			for _, metric := range page.Metrics {
+				ok, err := m.matches(metric)
+				if err != nil {
+					matchErr = err
+					return false
+				}
+				if ok {
+					metrics = append(metrics, metric)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	if matchErr != nil {
+		return nil, matchErr
+	}
+
+	ttl := defaultCacheTTL
+	if m.CacheTTL > 0 {
+		ttl = time.Duration(m.CacheTTL) * time.Second
+	}
+	m.cache = &metricCache{metrics: metrics, fetched: now, ttl: ttl}
+
+	return metrics, nil
+}
+
+// dimensionNames returns the exact set of dimensions discovered metrics must
+// carry. If MetricNames are given, this defaults to the configured
+// dimensions, just like when the metrics are not discovered.
+func (m *Metric) dimensionNames() []string {
+	if len(m.DimensionNames) > 0 || len(m.MetricNames) == 0 {
+		return m.DimensionNames
+	}
+	names := make([]string, 0, len(m.Dimensions))
+	for name := range m.Dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matches reports whether a listed metric is one of MetricNames (if set),
+// carries exactly the wanted dimensions and whether each configured
+// dimension matches one of its value patterns.
+func (m *Metric) matches(metric *cloudwatch.Metric) (bool, error) {
+	if len(m.MetricNames) > 0 {
+		found := false
+		for _, name := range m.MetricNames {
+			if name == *metric.MetricName {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	dimensionNames := m.dimensionNames()
+	if len(dimensionNames) > 0 {
+		if len(metric.Dimensions) != len(dimensionNames) {
+			return false, nil
+		}
+		names := make([]string, len(metric.Dimensions))
+		for i, d := range metric.Dimensions {
+			names[i] = *d.Name
+		}
+		wanted := make([]string, len(dimensionNames))
+		copy(wanted, dimensionNames)
+		sort.Strings(names)
+		sort.Strings(wanted)
+		for i := range names {
+			if names[i] != wanted[i] {
+				return false, nil
+			}
+		}
+	}
+
+	dims := dimsToTags(metric.Dimensions)
+	for name, patterns := range m.Dimensions {
+		value, ok := dims[name]
+		if !ok {
+			return false, nil
+		}
+		matched := false
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, value)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,278 @@
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// DynamoDB reports the provisioned and consumed capacity of DynamoDB tables.
+type DynamoDB struct {
+	ServiceConfig
+
+	// Tables lists the tables to report, by default every table of the
+	// region.
+	Tables []string
+
+	svc dynamoDBAPI
+	// cw reads the consumed capacity, which DynamoDB only publishes to
+	// CloudWatch.
+	cw cloudwatchiface.CloudWatchAPI
+
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+}
+
+// consumedCapacityWindow is how far back the newest consumed capacity
+// datapoint is looked for, as CloudWatch publishes it with a delay.
+const consumedCapacityWindow = 5 * time.Minute
+
+var dynamoDBSampleConfig = `
+  # Region of the tables
+  region = "us-east-1"
+  # Tables to report, by default every table of the region
+  # tables = ["users", "sessions"]
+
+  # Override the DynamoDB endpoint, e.g. for DynamoDB Local
+  # endpoint_url = "http://localhost:8000"
+  # Number of times a failed or throttled call is retried
+  # max_retries = 3
+
+  # Credentials, by default the SDK chain (environment, shared credentials
+  # file, EC2 instance role) is used
+  # access_key = ""
+  # secret_key = ""
+  # profile = ""
+  # role_arn = ""
+`
+
+func (d *DynamoDB) SampleConfig() string {
+	return dynamoDBSampleConfig
+}
+
+func (d *DynamoDB) Description() string {
+	return "Read the provisioned and consumed capacity of AWS DynamoDB tables."
+}
+
+func (d *DynamoDB) Validate() error {
+	var errs errorList
+	for _, err := range d.validate() {
+		errs.add(err)
+	}
+	return errs.err()
+}
+
+// Gather writes one point per table. A table that cannot be read does not
+// stop the others.
+func (d *DynamoDB) Gather(acc plugins.Accumulator) error {
+	if d.svc == nil {
+		d.svc = newDynamoDBClient(d.session())
+	}
+	if d.cw == nil {
+		// The endpoint only applies to DynamoDB
+		config := d.ServiceConfig
+		config.EndpointURL = ""
+		d.cw = cloudwatch.New(config.session())
+	}
+	if d.now == nil {
+		d.now = time.Now
+	}
+
+	tables := d.Tables
+	if len(tables) == 0 {
+		var err error
+		if tables, err = d.listTables(); err != nil {
+			return err
+		}
+	}
+
+	var errs errorList
+	for _, table := range tables {
+		if err := d.gatherTable(acc, table); err != nil {
+			errs.add(fmt.Errorf("%s: %s", table, err))
+		}
+	}
+	return errs.err()
+}
+
+// listTables returns the names of all tables of the region.
+func (d *DynamoDB) listTables() ([]string, error) {
+	var tables []string
+	input := &dynamoDBListTablesInput{}
+	for {
+		resp, err := d.svc.listTables(input)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, resp.TableNames...)
+		if resp.LastEvaluatedTableName == "" {
+			return tables, nil
+		}
+		input.ExclusiveStartTableName = resp.LastEvaluatedTableName
+	}
+}
+
+func (d *DynamoDB) gatherTable(acc plugins.Accumulator, table string) error {
+	resp, err := d.svc.describeTable(&dynamoDBDescribeTableInput{
+		TableName: table,
+	})
+	if err != nil {
+		return err
+	}
+
+	desc := resp.Table
+	throughput := desc.ProvisionedThroughput
+	fields := map[string]interface{}{
+		"item_count":           desc.ItemCount,
+		"table_size_bytes":     desc.TableSizeBytes,
+		"read_capacity_units":  throughput.ReadCapacityUnits,
+		"write_capacity_units": throughput.WriteCapacityUnits,
+	}
+
+	consumed := map[string]string{
+		"ConsumedReadCapacityUnits":  "consumed_read_capacity_units",
+		"ConsumedWriteCapacityUnits": "consumed_write_capacity_units",
+	}
+	// The table is still written without its consumed capacity when
+	// CloudWatch cannot be read
+	var cwErr error
+	for metricName, field := range consumed {
+		value, ok, err := d.consumedCapacity(table, metricName)
+		if err != nil {
+			cwErr = fmt.Errorf("could not read consumed capacity: %s", err)
+			break
+		}
+		if ok {
+			fields[field] = value
+		}
+	}
+
+	tags := map[string]string{
+		"table_name":   table,
+		"table_status": desc.TableStatus,
+		"region":       d.Region,
+	}
+	acc.AddFields("table", fields, tags)
+	return cwErr
+}
+
+// consumedCapacity returns the capacity units per second the table consumed
+// in the newest minute CloudWatch has published.
+func (d *DynamoDB) consumedCapacity(
+	table, metricName string,
+) (float64, bool, error) {
+	end := d.now().Truncate(time.Minute)
+	resp, err := d.cw.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/DynamoDB"),
+		MetricName: aws.String(metricName),
+		Dimensions: convertDimensions(map[string]string{"TableName": table}),
+		StartTime:  aws.Time(end.Add(-consumedCapacityWindow)),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int64(60),
+		Statistics: aws.StringSlice([]string{"Sum"}),
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	var newest *cloudwatch.Datapoint
+	for _, dp := range resp.Datapoints {
+		if newest == nil || dp.Timestamp.After(*newest.Timestamp) {
+			newest = dp
+		}
+	}
+	if newest == nil || newest.Sum == nil {
+		return 0, false, nil
+	}
+	return *newest.Sum / 60, true, nil
+}
+
+// The vendored SDK does not ship the DynamoDB service, so the calls used by
+// the collector are declared here.
+
+type dynamoDBListTablesInput struct {
+	ExclusiveStartTableName string `json:",omitempty"`
+}
+
+type dynamoDBListTablesOutput struct {
+	LastEvaluatedTableName string
+	TableNames             []string
+}
+
+type dynamoDBDescribeTableInput struct {
+	TableName string
+}
+
+type dynamoDBProvisionedThroughput struct {
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+}
+
+type dynamoDBTableDescription struct {
+	TableName             string
+	TableStatus           string
+	ItemCount             int64
+	TableSizeBytes        int64
+	ProvisionedThroughput dynamoDBProvisionedThroughput
+}
+
+type dynamoDBDescribeTableOutput struct {
+	Table dynamoDBTableDescription
+}
+
+// dynamoDBAPI is the subset of DynamoDB used by the collector.
+type dynamoDBAPI interface {
+	listTables(*dynamoDBListTablesInput) (*dynamoDBListTablesOutput, error)
+	describeTable(
+		*dynamoDBDescribeTableInput,
+	) (*dynamoDBDescribeTableOutput, error)
+}
+
+type dynamoDBClient struct {
+	*client.Client
+}
+
+func newDynamoDBClient(p client.ConfigProvider) *dynamoDBClient {
+	return &dynamoDBClient{
+		newJSONClient(p, "dynamodb", "2012-08-10", "1.0",
+			"DynamoDB_20120810"),
+	}
+}
+
+func (c *dynamoDBClient) listTables(
+	input *dynamoDBListTablesInput,
+) (*dynamoDBListTablesOutput, error) {
+	op := &request.Operation{
+		Name:       "ListTables",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &dynamoDBListTablesOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func (c *dynamoDBClient) describeTable(
+	input *dynamoDBDescribeTableInput,
+) (*dynamoDBDescribeTableOutput, error) {
+	op := &request.Operation{
+		Name:       "DescribeTable",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &dynamoDBDescribeTableOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func init() {
+	plugins.Add("aws_dynamodb", func() plugins.Plugin { return &DynamoDB{} })
+}
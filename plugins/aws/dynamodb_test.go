@@ -0,0 +1,136 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dynamoDBStub serves ListTables over two pages and DescribeTable.
+func dynamoDBStub(t *testing.T, targets *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		*targets = append(*targets, target)
+		assert.Equal(t, "application/x-amz-json-1.0",
+			r.Header.Get("Content-Type"))
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+
+		switch target {
+		case "DynamoDB_20120810.ListTables":
+			if body["ExclusiveStartTableName"] == "" {
+				fmt.Fprint(w, `{"TableNames": ["users"],
+"LastEvaluatedTableName": "users"}`)
+				return
+			}
+			fmt.Fprint(w, `{"TableNames": ["sessions"]}`)
+		case "DynamoDB_20120810.DescribeTable":
+			if body["TableName"] == "missing" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type": "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException",
+"message": "Requested resource not found"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"Table": {
+  "TableName": %q,
+  "TableStatus": "ACTIVE",
+  "ItemCount": 42,
+  "TableSizeBytes": 4096,
+  "ProvisionedThroughput": {"ReadCapacityUnits": 10, "WriteCapacityUnits": 5}
+}}`, body["TableName"])
+		}
+	}))
+}
+
+func TestDynamoDB(t *testing.T) {
+	var targets []string
+	ts := dynamoDBStub(t, &targets)
+	defer ts.Close()
+
+	d := &DynamoDB{
+		ServiceConfig: testServiceConfig(ts.URL),
+		cw:            &throttlingClient{},
+		now:           time.Now,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, d.Gather(&acc))
+
+	assert.Equal(t, []string{
+		"DynamoDB_20120810.ListTables",
+		"DynamoDB_20120810.ListTables",
+		"DynamoDB_20120810.DescribeTable",
+		"DynamoDB_20120810.DescribeTable",
+	}, targets)
+
+	require.Len(t, acc.Points, 2)
+	for _, table := range []string{"users", "sessions"} {
+		assert.True(t, acc.CheckTaggedFieldsValue("table",
+			map[string]interface{}{
+				"item_count":                    int64(42),
+				"table_size_bytes":              int64(4096),
+				"read_capacity_units":           int64(10),
+				"write_capacity_units":          int64(5),
+				"consumed_read_capacity_units":  0.05,
+				"consumed_write_capacity_units": 0.05,
+			},
+			map[string]string{
+				"table_name":   table,
+				"table_status": "ACTIVE",
+				"region":       "us-east-1",
+			}), table)
+	}
+}
+
+func TestDynamoDBError(t *testing.T) {
+	var targets []string
+	ts := dynamoDBStub(t, &targets)
+	defer ts.Close()
+
+	d := &DynamoDB{
+		ServiceConfig: testServiceConfig(ts.URL),
+		Tables:        []string{"missing", "users"},
+		cw:            &throttlingClient{},
+	}
+	var acc testutil.Accumulator
+	err := d.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing: ResourceNotFoundException")
+
+	assert.Len(t, acc.Points, 1)
+}
+
+func TestDynamoDBConsumedCapacityError(t *testing.T) {
+	var targets []string
+	ts := dynamoDBStub(t, &targets)
+	defer ts.Close()
+
+	d := &DynamoDB{
+		ServiceConfig: testServiceConfig(ts.URL),
+		Tables:        []string{"users"},
+		cw: &throttlingClient{failing: map[string]bool{
+			"ConsumedReadCapacityUnits":  true,
+			"ConsumedWriteCapacityUnits": true,
+		}},
+	}
+	var acc testutil.Accumulator
+	err := d.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "users: could not read consumed capacity")
+
+	// The capacity read from DynamoDB is still written
+	require.Len(t, acc.Points, 1)
+	assert.Equal(t, map[string]interface{}{
+		"item_count":           int64(42),
+		"table_size_bytes":     int64(4096),
+		"read_capacity_units":  int64(10),
+		"write_capacity_units": int64(5),
+	}, acc.Points[0].Fields)
+}
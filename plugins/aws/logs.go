@@ -0,0 +1,348 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// Logs runs CloudWatch Logs Insights queries and reports their results.
+type Logs struct {
+	ServiceConfig
+
+	Queries []LogsQuery
+
+	svc logsAPI
+
+	// now returns the current time and sleep waits between polls for
+	// results, both replaced in tests.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// LogsQuery is a Logs Insights query run over a window sliding with every
+// gather. Each row of the results becomes a point.
+type LogsQuery struct {
+	// Name is the measurement the rows are written to.
+	Name          string
+	LogGroupNames []string
+	Query         string
+	// Window is the number of seconds before the gather the query covers.
+	Window int64
+	// TagColumns are the columns written as tags. The other columns are
+	// written as fields if their value is a number, and dropped otherwise.
+	TagColumns []string
+	// TimeColumn is the column holding the time of the row, e.g. @timestamp
+	// or bin(5m). By default the rows are written at the gather time.
+	TimeColumn string
+	// Limit caps the number of rows returned, by default 1000.
+	Limit int64
+	// Timeout is the number of seconds to wait for the query to complete.
+	Timeout int64
+}
+
+const (
+	defaultLogsQueryTimeout = 60
+	// logsPollInterval is the time between two reads of the results of a
+	// running query.
+	logsPollInterval = time.Second
+	// logsTimeLayout is the layout of the times in query results.
+	logsTimeLayout = "2006-01-02 15:04:05.000"
+)
+
+var logsSampleConfig = `
+  # Region of the log groups
+  region = "us-east-1"
+
+  # Override the CloudWatch Logs endpoint, e.g. for a local mock server
+  # endpoint_url = "http://localhost:5000"
+  # Number of times a failed or throttled call is retried
+  # max_retries = 3
+
+  # Credentials, by default the SDK chain (environment, shared credentials
+  # file, EC2 instance role) is used
+  # access_key = ""
+  # secret_key = ""
+  # profile = ""
+  # role_arn = ""
+
+  # Logs Insights queries, run every interval over the last window seconds
+  [[aws_logs.queries]]
+    # Measurement the result rows are written to
+    name = "api_errors"
+    log_group_names = ["/aws/lambda/api"]
+    query = 'filter level = "ERROR" | stats count(*) as errors by service, bin(1m)'
+    window = 300
+    # Columns written as tags, the numeric columns are written as fields
+    tag_columns = ["service"]
+    # Column holding the time of each row, by default the gather time
+    time_column = "bin(1m)"
+    # Maximum number of rows returned
+    # limit = 1000
+    # Seconds to wait for the query to complete
+    # timeout = 60
+`
+
+func (l *Logs) SampleConfig() string {
+	return logsSampleConfig
+}
+
+func (l *Logs) Description() string {
+	return "Run AWS CloudWatch Logs Insights queries and read their results."
+}
+
+func (l *Logs) Validate() error {
+	var errs errorList
+	for _, err := range l.validate() {
+		errs.add(err)
+	}
+	if len(l.Queries) == 0 {
+		errs.add(fmt.Errorf("no [[aws_logs.queries]] configured"))
+	}
+	for i, q := range l.Queries {
+		for _, err := range q.validate() {
+			errs.add(fmt.Errorf("queries[%d]: %s", i, err))
+		}
+	}
+	return errs.err()
+}
+
+func (q *LogsQuery) validate() []error {
+	var errs []error
+	if q.Name == "" {
+		errs = append(errs, fmt.Errorf("name is not set"))
+	}
+	if q.Query == "" {
+		errs = append(errs, fmt.Errorf("query is not set"))
+	}
+	if len(q.LogGroupNames) == 0 {
+		errs = append(errs, fmt.Errorf("log_group_names is not set"))
+	}
+	if q.Window <= 0 {
+		errs = append(errs, fmt.Errorf("window must be positive"))
+	}
+	if q.Limit < 0 {
+		errs = append(errs, fmt.Errorf("limit must not be negative"))
+	}
+	if q.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("timeout must not be negative"))
+	}
+	return errs
+}
+
+// Gather runs the queries concurrently and writes one point per result row.
+// A query that fails does not stop the others.
+func (l *Logs) Gather(acc plugins.Accumulator) error {
+	if l.svc == nil {
+		l.svc = newLogsClient(l.session())
+	}
+	if l.now == nil {
+		l.now = time.Now
+	}
+	if l.sleep == nil {
+		l.sleep = time.Sleep
+	}
+
+	now := l.now()
+	var wg sync.WaitGroup
+	var errs errorList
+	for i := range l.Queries {
+		q := &l.Queries[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.gatherQuery(acc, q, now); err != nil {
+				errs.add(fmt.Errorf("%s: %s", q.Name, err))
+			}
+		}()
+	}
+	wg.Wait()
+	return errs.err()
+}
+
+func (l *Logs) gatherQuery(
+	acc plugins.Accumulator,
+	q *LogsQuery,
+	now time.Time,
+) error {
+	rows, err := l.run(q, now)
+	if err != nil {
+		return err
+	}
+
+	isTag := make(map[string]bool, len(q.TagColumns))
+	for _, column := range q.TagColumns {
+		isTag[column] = true
+	}
+
+	var errs errorList
+	for _, row := range rows {
+		fields := make(map[string]interface{})
+		tags := map[string]string{"region": l.Region}
+		t := now
+		for _, col := range row {
+			switch {
+			case col.Field == q.TimeColumn:
+				parsed, err := time.Parse(logsTimeLayout, col.Value)
+				if err != nil {
+					errs.add(fmt.Errorf("%s: %s", col.Field, err))
+					continue
+				}
+				t = parsed
+			case isTag[col.Field]:
+				tags[col.Field] = col.Value
+			default:
+				if v, err := strconv.ParseFloat(col.Value, 64); err == nil {
+					fields[col.Field] = v
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		acc.AddFields(q.Name, fields, tags, t)
+	}
+	return errs.err()
+}
+
+// run starts the query over the window ending at now and waits for its
+// results. A query still running after the timeout is stopped.
+func (l *Logs) run(q *LogsQuery, now time.Time) ([][]logsResultField, error) {
+	start, err := l.svc.startQuery(&logsStartQueryInput{
+		LogGroupNames: q.LogGroupNames,
+		QueryString:   q.Query,
+		StartTime:     now.Add(-time.Duration(q.Window) * time.Second).Unix(),
+		EndTime:       now.Unix(),
+		Limit:         q.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := q.Timeout
+	if timeout == 0 {
+		timeout = defaultLogsQueryTimeout
+	}
+	var waited time.Duration
+	for {
+		resp, err := l.svc.getQueryResults(&logsGetQueryResultsInput{
+			QueryId: start.QueryId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch resp.Status {
+		case "Complete":
+			return resp.Results, nil
+		case "Failed", "Cancelled", "Timeout":
+			return nil, fmt.Errorf("query %s: %s", start.QueryId, resp.Status)
+		}
+
+		if waited >= time.Duration(timeout)*time.Second {
+			// Free the slot the query holds in the concurrent query limit
+			l.svc.stopQuery(&logsStopQueryInput{QueryId: start.QueryId})
+			return nil, fmt.Errorf("query %s did not complete within %ds",
+				start.QueryId, timeout)
+		}
+		l.sleep(logsPollInterval)
+		waited += logsPollInterval
+	}
+}
+
+// The vendored SDK does not ship the CloudWatch Logs service, so the calls
+// used by the collector are declared here.
+
+type logsStartQueryInput struct {
+	LogGroupNames []string `json:"logGroupNames"`
+	QueryString   string   `json:"queryString"`
+	StartTime     int64    `json:"startTime"`
+	EndTime       int64    `json:"endTime"`
+	Limit         int64    `json:"limit,omitempty"`
+}
+
+type logsStartQueryOutput struct {
+	QueryId string `json:"queryId"`
+}
+
+type logsGetQueryResultsInput struct {
+	QueryId string `json:"queryId"`
+}
+
+type logsResultField struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type logsGetQueryResultsOutput struct {
+	Results [][]logsResultField `json:"results"`
+	Status  string              `json:"status"`
+}
+
+type logsStopQueryInput struct {
+	QueryId string `json:"queryId"`
+}
+
+type logsStopQueryOutput struct {
+	Success bool `json:"success"`
+}
+
+// logsAPI is the subset of CloudWatch Logs used by the collector.
+type logsAPI interface {
+	startQuery(*logsStartQueryInput) (*logsStartQueryOutput, error)
+	getQueryResults(
+		*logsGetQueryResultsInput,
+	) (*logsGetQueryResultsOutput, error)
+	stopQuery(*logsStopQueryInput) (*logsStopQueryOutput, error)
+}
+
+type logsClient struct {
+	*client.Client
+}
+
+func newLogsClient(p client.ConfigProvider) *logsClient {
+	return &logsClient{
+		newJSONClient(p, "logs", "2014-03-28", "1.1", "Logs_20140328"),
+	}
+}
+
+func (c *logsClient) send(name string, input, output interface{}) error {
+	op := &request.Operation{
+		Name:       name,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	return c.NewRequest(op, input, output).Send()
+}
+
+func (c *logsClient) startQuery(
+	input *logsStartQueryInput,
+) (*logsStartQueryOutput, error) {
+	output := &logsStartQueryOutput{}
+	err := c.send("StartQuery", input, output)
+	return output, err
+}
+
+func (c *logsClient) getQueryResults(
+	input *logsGetQueryResultsInput,
+) (*logsGetQueryResultsOutput, error) {
+	output := &logsGetQueryResultsOutput{}
+	err := c.send("GetQueryResults", input, output)
+	return output, err
+}
+
+func (c *logsClient) stopQuery(
+	input *logsStopQueryInput,
+) (*logsStopQueryOutput, error) {
+	output := &logsStopQueryOutput{}
+	err := c.send("StopQuery", input, output)
+	return output, err
+}
+
+func init() {
+	plugins.Add("aws_logs", func() plugins.Plugin { return &Logs{} })
+}
@@ -0,0 +1,210 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/naoina/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logsStub serves StartQuery and answers GetQueryResults with status
+// Running polls times before returning the results. Queries on the group
+// /broken fail.
+type logsStub struct {
+	sync.Mutex
+	t       *testing.T
+	polls   int
+	started []map[string]interface{}
+	stopped []string
+	results map[string]int
+}
+
+func (s *logsStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	assert.Equal(s.t, "application/x-amz-json-1.1", r.Header.Get("Content-Type"))
+	var body map[string]interface{}
+	require.NoError(s.t, json.NewDecoder(r.Body).Decode(&body))
+
+	switch r.Header.Get("X-Amz-Target") {
+	case "Logs_20140328.StartQuery":
+		s.started = append(s.started, body)
+		groups := body["logGroupNames"].([]interface{})
+		fmt.Fprintf(w, `{"queryId": %q}`, groups[0])
+	case "Logs_20140328.GetQueryResults":
+		id := body["queryId"].(string)
+		if id == "/broken" {
+			fmt.Fprint(w, `{"status": "Failed"}`)
+			return
+		}
+		if s.results[id] < s.polls {
+			s.results[id]++
+			fmt.Fprint(w, `{"status": "Running", "results": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"status": "Complete", "results": [
+  [{"field": "service", "value": "auth"},
+   {"field": "bin(1m)", "value": "2016-01-02 15:04:00.000"},
+   {"field": "errors", "value": "3"},
+   {"field": "message", "value": "timeout"}],
+  [{"field": "service", "value": "billing"},
+   {"field": "bin(1m)", "value": "2016-01-02 15:05:00.000"},
+   {"field": "errors", "value": "1.5"}],
+  [{"field": "service", "value": "empty"},
+   {"field": "@ptr", "value": "abc"}]
+]}`)
+	case "Logs_20140328.StopQuery":
+		s.stopped = append(s.stopped, body["queryId"].(string))
+		fmt.Fprint(w, `{"success": true}`)
+	}
+}
+
+func newLogsStub(t *testing.T, polls int) *logsStub {
+	return &logsStub{t: t, polls: polls, results: make(map[string]int)}
+}
+
+func testLogs(endpoint string, queries ...LogsQuery) *Logs {
+	return &Logs{
+		ServiceConfig: testServiceConfig(endpoint),
+		Queries:       queries,
+		now: func() time.Time {
+			return time.Unix(1451747400, 0)
+		},
+		sleep: func(time.Duration) {},
+	}
+}
+
+func TestLogs(t *testing.T) {
+	stub := newLogsStub(t, 2)
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	l := testLogs(ts.URL, LogsQuery{
+		Name:          "api_errors",
+		LogGroupNames: []string{"/aws/lambda/api"},
+		Query:         "stats count(*) as errors by service, bin(1m)",
+		Window:        300,
+		TagColumns:    []string{"service"},
+		TimeColumn:    "bin(1m)",
+	})
+	var acc testutil.Accumulator
+	require.NoError(t, l.Gather(&acc))
+
+	require.Len(t, stub.started, 1)
+	assert.Equal(t, float64(1451747100), stub.started[0]["startTime"])
+	assert.Equal(t, float64(1451747400), stub.started[0]["endTime"])
+	assert.Equal(t, 2, stub.results["/aws/lambda/api"])
+
+	// The row without numeric column is dropped
+	require.Len(t, acc.Points, 2)
+	assert.Equal(t, "api_errors", acc.Points[0].Measurement)
+	assert.Equal(t, map[string]interface{}{"errors": 3.0},
+		acc.Points[0].Fields)
+	assert.Equal(t, map[string]string{"service": "auth", "region": "us-east-1"},
+		acc.Points[0].Tags)
+	assert.Equal(t, time.Date(2016, 1, 2, 15, 4, 0, 0, time.UTC),
+		acc.Points[0].Time)
+	assert.Equal(t, map[string]interface{}{"errors": 1.5},
+		acc.Points[1].Fields)
+	assert.Equal(t, time.Date(2016, 1, 2, 15, 5, 0, 0, time.UTC),
+		acc.Points[1].Time)
+}
+
+func TestLogsDefaultsToGatherTime(t *testing.T) {
+	ts := httptest.NewServer(newLogsStub(t, 0))
+	defer ts.Close()
+
+	l := testLogs(ts.URL, LogsQuery{
+		Name:          "errors",
+		LogGroupNames: []string{"/app"},
+		Query:         "stats count(*) as errors by service, bin(1m)",
+		Window:        60,
+	})
+	var acc testutil.Accumulator
+	require.NoError(t, l.Gather(&acc))
+
+	require.Len(t, acc.Points, 2)
+	for _, p := range acc.Points {
+		assert.Equal(t, time.Unix(1451747400, 0), p.Time)
+		// Columns not listed as tags are only kept if numeric
+		assert.Equal(t, map[string]string{"region": "us-east-1"}, p.Tags)
+	}
+}
+
+func TestLogsQueryErrors(t *testing.T) {
+	stub := newLogsStub(t, 5)
+	ts := httptest.NewServer(stub)
+	defer ts.Close()
+
+	l := testLogs(ts.URL,
+		LogsQuery{
+			Name:          "broken",
+			LogGroupNames: []string{"/broken"},
+			Query:         "stats count(*)",
+			Window:        60,
+		},
+		LogsQuery{
+			Name:          "slow",
+			LogGroupNames: []string{"/slow"},
+			Query:         "stats count(*)",
+			Window:        60,
+			Timeout:       2,
+		},
+		LogsQuery{
+			Name:          "ok",
+			LogGroupNames: []string{"/ok"},
+			Query:         "stats count(*) as errors by service",
+			Window:        60,
+			Timeout:       10,
+		},
+	)
+	var acc testutil.Accumulator
+	err := l.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken: query /broken: Failed")
+	assert.Contains(t, err.Error(),
+		"slow: query /slow did not complete within 2s")
+
+	assert.Equal(t, []string{"/slow"}, stub.stopped)
+	assert.Len(t, acc.Points, 2)
+}
+
+func TestLogsSampleConfig(t *testing.T) {
+	var config struct {
+		AwsLogs Logs
+	}
+	require.NoError(t, toml.Unmarshal(
+		[]byte("[aws_logs]"+logsSampleConfig), &config))
+
+	l := config.AwsLogs
+	require.NoError(t, l.Validate())
+	require.Len(t, l.Queries, 1)
+	assert.Equal(t, []string{"service"}, l.Queries[0].TagColumns)
+	assert.Contains(t, l.Queries[0].Query, "stats count(*) as errors")
+}
+
+func TestLogsValidate(t *testing.T) {
+	l := &Logs{ServiceConfig: ServiceConfig{Region: "us-east-1"}}
+	require.EqualError(t, l.Validate(), "no [[aws_logs.queries]] configured")
+
+	l.Queries = []LogsQuery{{Name: "errors", Limit: -1}}
+	err := l.Validate()
+	require.Error(t, err)
+	for _, msg := range []string{
+		"queries[0]: query is not set",
+		"queries[0]: log_group_names is not set",
+		"queries[0]: window must be positive",
+		"queries[0]: limit must not be negative",
+	} {
+		assert.Contains(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,221 @@
+package aws
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// iso8601 is the timestamp layout used by the CloudWatch query API.
+const iso8601 = "2006-01-02T15:04:05Z"
+
+// maxMetricDataQueries is the number of queries GetMetricData accepts in a
+// single request.
+const maxMetricDataQueries = 500
+
+const opGetMetricData = "GetMetricData"
+
+// The vendored SDK predates GetMetricData, so the request and response shapes
+// are declared here. They are marshalled by the SDK's query protocol handlers
+// exactly like the generated types in service/cloudwatch.
+
+type metricStat struct {
+	Metric *cloudwatch.Metric `type:"structure" required:"true"`
+	Period *int64             `min:"1" type:"integer" required:"true"`
+	Stat   *string            `type:"string" required:"true"`
+	Unit   *string            `type:"string"`
+}
+
+type metricDataQuery struct {
+	Id         *string     `min:"1" type:"string" required:"true"`
+	MetricStat *metricStat `type:"structure"`
+	ReturnData *bool       `type:"boolean"`
+}
+
+type getMetricDataInput struct {
+	EndTime           *time.Time         `type:"timestamp" timestampFormat:"iso8601" required:"true"`
+	MetricDataQueries []*metricDataQuery `type:"list" required:"true"`
+	NextToken         *string            `type:"string"`
+	StartTime         *time.Time         `type:"timestamp" timestampFormat:"iso8601" required:"true"`
+}
+
+// metricDataResult keeps its timestamps as strings: the SDK unmarshaller
+// only recognises timestamps through field tags, which list members lack.
+type metricDataResult struct {
+	Id         *string    `type:"string"`
+	Label      *string    `type:"string"`
+	StatusCode *string    `type:"string"`
+	Timestamps []*string  `type:"list"`
+	Values     []*float64 `type:"list"`
+}
+
+type getMetricDataOutput struct {
+	MetricDataResults []*metricDataResult `type:"list"`
+	NextToken         *string             `type:"string"`
+}
+
+// metricDataAPI is implemented by clients that can serve batched
+// GetMetricData requests.
+type metricDataAPI interface {
+	GetMetricData(*getMetricDataInput) (*getMetricDataOutput, error)
+}
+
+// metricDataClient adds GetMetricData to the vendored CloudWatch client.
+type metricDataClient struct {
+	*cloudwatch.CloudWatch
+}
+
+func (c *metricDataClient) GetMetricData(
+	input *getMetricDataInput,
+) (*getMetricDataOutput, error) {
+	op := &request.Operation{
+		Name:       opGetMetricData,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &getMetricDataOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+// isBatchUnsupported returns true if err indicates the endpoint does not
+// know about GetMetricData, as is the case for some local stand-ins.
+func isBatchUnsupported(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "InvalidAction", "UnknownOperationException", "NotImplemented":
+			return true
+		}
+	}
+	return false
+}
+
+// batchQuery ties a GetMetricData query id back to the metric and statistic
+// it was built from.
+type batchQuery struct {
+	metric    *cloudwatch.Metric
+	statistic string
+}
+
+// gatherBatched requests statistics for all metrics with as few
+// GetMetricData calls as possible and adds the results to acc using the same
+// measurement names and tags as the GetMetricStatistics path. GetMetricData
+// does not report units, so the configured Unit, if any, is used instead.
+func (m *Metric) gatherBatched(
+	svc metricDataAPI,
+	metrics []*cloudwatch.Metric,
+	start, end time.Time,
+	acc plugins.Accumulator,
+) error {
+	var statistics []string
+	for _, statistic := range m.Statistics {
+		if _, ok := statisticFields[statistic]; ok {
+			statistics = append(statistics, statistic)
+		}
+	}
+	statistics = append(statistics, m.ExtendedStatistics...)
+
+	var queries []batchQuery
+	for _, metric := range metrics {
+		for _, statistic := range statistics {
+			queries = append(queries, batchQuery{metric, statistic})
+		}
+	}
+
+	ps := newPointSet()
+	var wg sync.WaitGroup
+	var errs errorList
+	for offset := 0; offset < len(queries); offset += maxMetricDataQueries {
+		limit := offset + maxMetricDataQueries
+		if limit > len(queries) {
+			limit = len(queries)
+		}
+		batch := queries[offset:limit]
+		m.pool.run(&wg, func() {
+			errs.add(m.gatherBatch(svc, batch, start, end, ps))
+		})
+	}
+	wg.Wait()
+
+	// Every batch is rejected the same way if the endpoint lacks
+	// GetMetricData, so report it once for the caller to fall back.
+	for _, err := range errs.errs {
+		if isBatchUnsupported(err) {
+			return err
+		}
+	}
+
+	errs.add(m.write(acc, ps))
+	return errs.err()
+}
+
+// gatherBatch sends a single batch of at most maxMetricDataQueries queries,
+// following NextToken until all pages are read.
+func (m *Metric) gatherBatch(
+	svc metricDataAPI,
+	queries []batchQuery,
+	start, end time.Time,
+	ps *pointSet,
+) error {
+	params := &getMetricDataInput{
+		StartTime: aws.Time(start),
+		EndTime:   aws.Time(end),
+	}
+	byID := make(map[string]batchQuery, len(queries))
+	for i, q := range queries {
+		id := fmt.Sprintf("q%d", i)
+		byID[id] = q
+		params.MetricDataQueries = append(params.MetricDataQueries,
+			&metricDataQuery{
+				Id: aws.String(id),
+				MetricStat: &metricStat{
+					Metric: q.metric,
+					Period: aws.Int64(m.Period),
+					Stat:   aws.String(q.statistic),
+				},
+			})
+		if m.Unit != "" {
+			params.MetricDataQueries[i].MetricStat.Unit = aws.String(m.Unit)
+		}
+	}
+
+	printDebug("requesting metric data for ", len(queries), " queries")
+
+	for {
+		resp, err := svc.GetMetricData(params)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range resp.MetricDataResults {
+			q, ok := byID[*result.Id]
+			if !ok {
+				continue
+			}
+			tags := dimsToTags(q.metric.Dimensions)
+			for i, ts := range result.Timestamps {
+				if i >= len(result.Values) {
+					break
+				}
+				t, err := time.Parse(iso8601, *ts)
+				if err != nil {
+					return err
+				}
+				m.add(ps, *q.metric.MetricName, q.statistic,
+					*result.Values[i], tags, m.Unit, t)
+			}
+		}
+
+		if resp.NextToken == nil || *resp.NextToken == "" {
+			return nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
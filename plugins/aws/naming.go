@@ -0,0 +1,121 @@
+package aws
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// templateVariable matches the variables of a measurement name template,
+// e.g. {metric}.
+var templateVariable = regexp.MustCompile(`\{(\w*)\}`)
+
+// templateVariables are the variables a measurement name template may use.
+var templateVariables = map[string]bool{
+	"prefix":    true,
+	"namespace": true,
+	"metric":    true,
+	"stat":      true,
+	"region":    true,
+}
+
+// measurementName returns the name of the measurement for a metric. The
+// statistic is only used by templates writing each statistic on its own.
+func (m *Metric) measurementName(metricName, statistic string) string {
+	metric := m.metricName(metricName)
+	if m.MeasurementName == "" {
+		if m.Prefix == "" {
+			return metric
+		}
+		return m.Prefix + "_" + metric
+	}
+
+	return templateVariable.ReplaceAllStringFunc(m.MeasurementName,
+		func(variable string) string {
+			switch variable[1 : len(variable)-1] {
+			case "prefix":
+				return m.Prefix
+			case "namespace":
+				return m.metricName(strings.Replace(m.Namespace, "/", "_", -1))
+			case "metric":
+				return metric
+			case "stat":
+				return statistic
+			case "region":
+				return m.Region
+			}
+			return variable
+		})
+}
+
+// metricName returns name as used in measurement names, converted to
+// snake_case if the block asks for it.
+func (m *Metric) metricName(name string) string {
+	if m.SnakeCase {
+		return snakeCase(name)
+	}
+	return name
+}
+
+// splitsStatistics returns true if the measurement name template writes
+// every statistic as its own measurement.
+func (m *Metric) splitsStatistics() bool {
+	return strings.Contains(m.MeasurementName, "{stat}")
+}
+
+// addBlockTags adds the tags configured for the block, without replacing
+// the dimension or other tags of the point.
+func (m *Metric) addBlockTags(tags map[string]string) {
+	for key, value := range m.Tags {
+		if _, ok := tags[key]; !ok {
+			tags[key] = value
+		}
+	}
+}
+
+// validateMeasurementName returns an error for each unknown variable of the
+// measurement name template.
+func (m *Metric) validateMeasurementName() []error {
+	var errs []error
+	for _, match := range templateVariable.FindAllStringSubmatch(
+		m.MeasurementName, -1) {
+		if !templateVariables[match[1]] {
+			errs = append(errs, fmt.Errorf(
+				"unknown variable %s in measurement_name, expected one of "+
+					"{prefix}, {namespace}, {metric}, {stat}, {region}",
+				match[0]))
+		}
+	}
+	return errs
+}
+
+// snakeCase converts a CamelCase name to snake_case, keeping acronyms
+// together: CPUUtilization becomes cpu_utilization and HTTPCode_Backend_5XX
+// becomes http_code_backend_5xx.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || (unicode.IsUpper(prev) && nextLower) {
+				out = append(out, '_')
+			}
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+	return string(out)
+}
+
+// sortedFields returns the field names of fields in order.
+func sortedFields(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,92 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnakeCase(t *testing.T) {
+	var tests = []struct {
+		name, expected string
+	}{
+		{"CPUUtilization", "cpu_utilization"},
+		{"NetworkIn", "network_in"},
+		{"HTTPCode_Backend_5XX", "http_code_backend_5xx"},
+		{"ApproximateNumberOfMessagesVisible", "approximate_number_of_messages_visible"},
+		{"AWS_EC2", "aws_ec2"},
+		{"latency", "latency"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, snakeCase(test.name))
+	}
+}
+
+func TestMeasurementName(t *testing.T) {
+	m := &Metric{Region: "us-east-1", Namespace: "AWS/EC2", Prefix: "ec2"}
+	assert.Equal(t, "ec2_CPUUtilization", m.measurementName("CPUUtilization", ""))
+
+	m.SnakeCase = true
+	assert.Equal(t, "ec2_cpu_utilization", m.measurementName("CPUUtilization", ""))
+
+	m.MeasurementName = "{namespace}.{metric}.{stat}.{region}"
+	assert.Equal(t, "aws_ec2.cpu_utilization.average.us-east-1",
+		m.measurementName("CPUUtilization", "average"))
+	assert.True(t, m.splitsStatistics())
+}
+
+func TestGatherMeasurementTemplate(t *testing.T) {
+	m := &Metric{
+		Region:          "us-east-1",
+		Namespace:       "AWS/EC2",
+		MetricNames:     []string{"CPUUtilization"},
+		Statistics:      []string{"Average", "Maximum"},
+		Period:          60,
+		Duration:        60,
+		MeasurementName: "{namespace}_{metric}_{stat}",
+		SnakeCase:       true,
+		Tags: map[string]string{
+			"environment": "production",
+			"region":      "ignored",
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(&mockMetricDataClient{}, &acc, time.Now()))
+
+	require.Len(t, acc.Points, 2)
+	tags := map[string]string{
+		"namespace":   "AWS/EC2",
+		"region":      "us-east-1",
+		"environment": "production",
+	}
+	assert.True(t, acc.CheckTaggedFieldsValue("aws_ec2_cpu_utilization_average",
+		map[string]interface{}{"value": 2.5}, tags))
+	assert.True(t, acc.CheckTaggedFieldsValue("aws_ec2_cpu_utilization_maximum",
+		map[string]interface{}{"value": 2.5}, tags))
+}
+
+func TestGatherBlockTagsLegacy(t *testing.T) {
+	m := &Metric{
+		Namespace:   "AWS/ELB",
+		Prefix:      "elb",
+		MetricNames: []string{"RequestCount"},
+		Statistics:  []string{"Sum"},
+		Period:      60,
+		Duration:    60,
+		SnakeCase:   true,
+		Tags:        map[string]string{"environment": "production"},
+		legacy:      true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(&mockMetricDataClient{}, &acc, time.Now()))
+
+	require.Len(t, acc.Points, 1)
+	assert.True(t, acc.CheckTaggedFieldsValue("elb_request_count_sum",
+		map[string]interface{}{"value": 2.5},
+		map[string]string{"environment": "production"}))
+}
@@ -0,0 +1,142 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// point holds the statistics of one metric at one timestamp, so they can be
+// written together as fields of a single measurement.
+type point struct {
+	metricName string
+	tags       map[string]string
+	unit       string
+	fields     map[string]interface{}
+	time       time.Time
+}
+
+// pointSet groups statistic values into points. Points are kept in the order
+// they were first seen so output is stable across runs.
+type pointSet struct {
+	sync.Mutex
+
+	points []*point
+	byKey  map[string]*point
+}
+
+func newPointSet() *pointSet {
+	return &pointSet{byKey: make(map[string]*point)}
+}
+
+// add records a single statistic value, unless the datapoint was already
+// emitted by an earlier gather.
+func (m *Metric) add(
+	ps *pointSet,
+	metricName string,
+	statistic string,
+	value float64,
+	tags map[string]string,
+	unit string,
+	t time.Time,
+) {
+	namespace := m.seriesNamespace()
+	key := seriesKey(namespace, metricName, tags, statistic)
+	if !m.state.isNew(key, t) {
+		return
+	}
+
+	ps.Lock()
+	defer ps.Unlock()
+
+	key = seriesKey(namespace, metricName, tags, t.Format(time.RFC3339Nano))
+	p, ok := ps.byKey[key]
+	if !ok {
+		p = &point{
+			metricName: metricName,
+			tags:       tags,
+			fields:     make(map[string]interface{}),
+			time:       t,
+		}
+		ps.byKey[key] = p
+		ps.points = append(ps.points, p)
+	}
+	if unit != "" {
+		p.unit = unit
+	}
+	p.fields[statisticField(statistic)] = value
+}
+
+// seriesNamespace qualifies the namespace of the block's series with the
+// region, credentials and period, so that blocks watching the same metric
+// from different regions or accounts, or at different periods, never share
+// their deduplication state.
+func (m *Metric) seriesNamespace() string {
+	return strings.Join([]string{
+		m.Region,
+		m.CredentialConfig.Identity(),
+		strconv.FormatInt(m.Period, 10),
+		m.Namespace,
+	}, "/")
+}
+
+// write adds the collected points to acc. By default each point becomes one
+// measurement named prefix_metric, or after the block's template, with a
+// field per statistic. Templates using {stat} and the legacy layout write
+// every statistic as its own measurement with a single value field; legacy
+// measurements are named prefix_metric_statistic.
+//
+// If the block enriches its metrics, the allowed resource tags of the
+// resources named by the dimensions are added to the tags. A failed lookup
+// is returned after the points were written without the missing tags.
+func (m *Metric) write(acc plugins.Accumulator, ps *pointSet) error {
+	now := time.Now()
+	err := m.lookupResourceTags(ps, now)
+	if err != nil {
+		err = fmt.Errorf("could not look up resource tags: %s", err)
+	}
+
+	for _, p := range ps.points {
+		if m.legacy {
+			for _, field := range sortedFields(p.fields) {
+				label := strings.Join(
+					[]string{m.Prefix, m.metricName(p.metricName), field}, "_")
+				tags := copyDims(p.tags)
+				if m.multiRegion {
+					tags["region"] = m.Region
+				}
+				m.addBlockTags(tags)
+				m.addResourceTags(tags, p.tags, now)
+				acc.Add(label, p.fields[field], tags, p.time)
+			}
+			continue
+		}
+
+		tags := copyDims(p.tags)
+		tags["namespace"] = m.Namespace
+		if m.Region != "" {
+			tags["region"] = m.Region
+		}
+		if p.unit != "" {
+			tags["unit"] = p.unit
+		}
+		m.addBlockTags(tags)
+		m.addResourceTags(tags, p.tags, now)
+
+		if m.splitsStatistics() {
+			for _, field := range sortedFields(p.fields) {
+				acc.AddFields(m.measurementName(p.metricName, field),
+					map[string]interface{}{"value": p.fields[field]},
+					copyDims(tags), p.time)
+			}
+			continue
+		}
+		acc.AddFields(m.measurementName(p.metricName, ""), p.fields, tags,
+			p.time)
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package aws
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultResourceTagsTTL is used when a block enriching its metrics sets no
+// ResourceTagsTTL.
+const defaultResourceTagsTTL = time.Hour
+
+// TagLookup finds the tags of the AWS resources a CloudWatch dimension
+// refers to, e.g. EC2 instances for InstanceId.
+type TagLookup interface {
+	// LookupTags returns the tags of the resources with the given ids,
+	// keyed by id. Unknown resources are left out.
+	LookupTags(ids []string) (map[string]map[string]string, error)
+}
+
+// resourceTagCache keeps the looked up tags of each resource for ttl.
+type resourceTagCache struct {
+	sync.Mutex
+
+	ttl     time.Duration
+	entries map[string]resourceTags
+}
+
+type resourceTags struct {
+	tags    map[string]string
+	fetched time.Time
+}
+
+func newResourceTagCache(ttl time.Duration) *resourceTagCache {
+	return &resourceTagCache{
+		ttl:     ttl,
+		entries: make(map[string]resourceTags),
+	}
+}
+
+// get returns the cached tags of the resource named value of the given
+// dimension, if they are still fresh at time now.
+func (c *resourceTagCache) get(
+	dimension, value string,
+	now time.Time,
+) (map[string]string, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, ok := c.entries[dimension+"|"+value]
+	if !ok || !now.Before(entry.fetched.Add(c.ttl)) {
+		return nil, false
+	}
+	return entry.tags, true
+}
+
+func (c *resourceTagCache) set(
+	dimension, value string,
+	tags map[string]string,
+	now time.Time,
+) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries[dimension+"|"+value] = resourceTags{tags, now}
+}
+
+// lookupResourceTags makes sure the cache holds the tags of every resource
+// referred to by the points in ps, looking up the missing ones. Resources
+// unknown to the lookup are cached without tags so they are not looked up
+// again before the TTL expires.
+func (m *Metric) lookupResourceTags(ps *pointSet, now time.Time) error {
+	if len(m.ResourceTags) == 0 || len(m.tagLookups) == 0 {
+		return nil
+	}
+	if m.resourceTags == nil {
+		ttl := defaultResourceTagsTTL
+		if m.ResourceTagsTTL > 0 {
+			ttl = time.Duration(m.ResourceTagsTTL) * time.Second
+		}
+		m.resourceTags = newResourceTagCache(ttl)
+	}
+
+	missing := make(map[string]map[string]bool)
+	for _, p := range ps.points {
+		for dimension, value := range p.tags {
+			if _, ok := m.tagLookups[dimension]; !ok {
+				continue
+			}
+			if _, ok := m.resourceTags.get(dimension, value, now); ok {
+				continue
+			}
+			if missing[dimension] == nil {
+				missing[dimension] = make(map[string]bool)
+			}
+			missing[dimension][value] = true
+		}
+	}
+
+	var errs errorList
+	for dimension, values := range missing {
+		ids := make([]string, 0, len(values))
+		for id := range values {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		printDebug("looking up tags for ", dimension, ": ", ids)
+
+		found, err := m.tagLookups[dimension].LookupTags(ids)
+		if err != nil {
+			errs.add(fmt.Errorf("%s: %s", dimension, err))
+			continue
+		}
+		for _, id := range ids {
+			m.resourceTags.set(dimension, id, found[id], now)
+		}
+	}
+	return errs.err()
+}
+
+// addResourceTags adds the allowed resource tags of the resources referred
+// to by dims to tags. Existing tags, such as the dimensions themselves, are
+// never overwritten.
+func (m *Metric) addResourceTags(
+	tags map[string]string,
+	dims map[string]string,
+	now time.Time,
+) {
+	if m.resourceTags == nil {
+		return
+	}
+	for dimension, value := range dims {
+		resource, ok := m.resourceTags.get(dimension, value, now)
+		if !ok {
+			continue
+		}
+		for _, key := range m.ResourceTags {
+			v, ok := resource[key]
+			if !ok {
+				continue
+			}
+			if _, exists := tags[key]; !exists {
+				tags[key] = v
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTagLookup serves tags from a map and records the ids looked up, or
+// fails with err if set.
+type fakeTagLookup struct {
+	tags    map[string]map[string]string
+	lookups [][]string
+	err     error
+}
+
+func (l *fakeTagLookup) LookupTags(
+	ids []string,
+) (map[string]map[string]string, error) {
+	l.lookups = append(l.lookups, ids)
+	if l.err != nil {
+		return nil, l.err
+	}
+	found := make(map[string]map[string]string)
+	for _, id := range ids {
+		if tags, ok := l.tags[id]; ok {
+			found[id] = tags
+		}
+	}
+	return found, nil
+}
+
+func TestGatherResourceTags(t *testing.T) {
+	lookup := &fakeTagLookup{
+		tags: map[string]map[string]string{
+			"i-abc": {"Name": "web-1", "team": "frontend", "cost": "42"},
+			"i-def": {"Name": "web-2", "InstanceId": "overwritten"},
+		},
+	}
+	m := &Metric{
+		Namespace:      "AWS/EC2",
+		Prefix:         "ec2",
+		Statistics:     []string{"Average"},
+		Period:         60,
+		Duration:       120,
+		DimensionNames: []string{"InstanceId"},
+		ResourceTags:   []string{"Name", "team", "InstanceId"},
+		tagLookups:     map[string]TagLookup{"InstanceId": lookup},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.gather(fakeEC2Client(), &acc, time.Now()))
+
+	require.Len(t, acc.Points, 3)
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{
+			"InstanceId": "i-abc",
+			"namespace":  "AWS/EC2",
+			"Name":       "web-1",
+			"team":       "frontend",
+		}))
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{
+			"InstanceId": "i-def",
+			"namespace":  "AWS/EC2",
+			"Name":       "web-2",
+		}))
+	assert.True(t, acc.CheckTaggedFieldsValue("ec2_CPUUtilization",
+		map[string]interface{}{"average": 1.5},
+		map[string]string{"InstanceId": "x-123", "namespace": "AWS/EC2"}))
+
+	require.Len(t, lookup.lookups, 1)
+	assert.Equal(t, []string{"i-abc", "i-def", "x-123"}, lookup.lookups[0])
+
+	// Tags are cached, including the absence of tags for x-123
+	require.NoError(t, m.gather(fakeEC2Client(), &acc, time.Now().Add(time.Minute)))
+	assert.Len(t, lookup.lookups, 1)
+}
+
+func TestGatherResourceTagsError(t *testing.T) {
+	lookup := &fakeTagLookup{err: fmt.Errorf("UnauthorizedOperation")}
+	m := &Metric{
+		Namespace:      "AWS/EC2",
+		Prefix:         "ec2",
+		Statistics:     []string{"Average"},
+		Period:         60,
+		Duration:       120,
+		DimensionNames: []string{"InstanceId"},
+		ResourceTags:   []string{"Name"},
+		tagLookups:     map[string]TagLookup{"InstanceId": lookup},
+	}
+
+	// The points are still written, without the resource tags
+	var acc testutil.Accumulator
+	err := m.gather(fakeEC2Client(), &acc, time.Now())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(),
+		"could not look up resource tags: InstanceId: UnauthorizedOperation")
+	require.Len(t, acc.Points, 3)
+	assert.Equal(t, map[string]string{
+		"InstanceId": "i-abc",
+		"namespace":  "AWS/EC2",
+	}, acc.Points[0].Tags)
+}
+
+func TestResourceTagCache(t *testing.T) {
+	c := newResourceTagCache(time.Minute)
+	now := time.Now()
+
+	_, ok := c.get("InstanceId", "i-abc", now)
+	assert.False(t, ok)
+
+	c.set("InstanceId", "i-abc", map[string]string{"Name": "web-1"}, now)
+	tags, ok := c.get("InstanceId", "i-abc", now.Add(59*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, "web-1", tags["Name"])
+
+	_, ok = c.get("LoadBalancerName", "i-abc", now)
+	assert.False(t, ok)
+
+	_, ok = c.get("InstanceId", "i-abc", now.Add(time.Minute))
+	assert.False(t, ok)
+}
+
+const ec2DescribeTagsResponse = `<DescribeTagsResponse xmlns="http://ec2.amazonaws.com/doc/2015-10-01/">
+  <requestId>7a62c49f-347e-4fc4-9331-6e8eEXAMPLE</requestId>
+  <tagSet>
+    <item>
+      <resourceId>i-abc</resourceId>
+      <resourceType>instance</resourceType>
+      <key>Name</key>
+      <value>web-1</value>
+    </item>
+    <item>
+      <resourceId>i-abc</resourceId>
+      <resourceType>instance</resourceType>
+      <key>team</key>
+      <value>frontend</value>
+    </item>
+  </tagSet>
+</DescribeTagsResponse>`
+
+func testSession(endpoint string) *session.Session {
+	return session.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+}
+
+func TestEC2TagLookup(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, ec2DescribeTagsResponse)
+	}))
+	defer ts.Close()
+
+	found, err := newEC2TagLookup(testSession(ts.URL)).
+		LookupTags([]string{"i-abc", "i-def"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "DescribeTags", form.Get("Action"))
+	assert.Equal(t, "resource-id", form.Get("Filter.1.Name"))
+	assert.Equal(t, "i-abc", form.Get("Filter.1.Value.1"))
+	assert.Equal(t, "i-def", form.Get("Filter.1.Value.2"))
+
+	assert.Equal(t, map[string]map[string]string{
+		"i-abc": {"Name": "web-1", "team": "frontend"},
+	}, found)
+}
+
+func TestEC2TagLookupError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Response><Errors><Error><Code>UnauthorizedOperation</Code>
+<Message>not allowed</Message></Error></Errors><RequestID>1</RequestID></Response>`)
+	}))
+	defer ts.Close()
+
+	_, err := newEC2TagLookup(testSession(ts.URL)).LookupTags([]string{"i-abc"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "UnauthorizedOperation")
+}
+
+const elbDescribeTagsResponse = `<DescribeTagsResponse xmlns="http://elasticloadbalancing.amazonaws.com/doc/2012-06-01/">
+  <DescribeTagsResult>
+    <TagDescriptions>
+      <member>
+        <LoadBalancerName>my-lb</LoadBalancerName>
+        <Tags>
+          <member>
+            <Key>env</Key>
+            <Value>prod</Value>
+          </member>
+        </Tags>
+      </member>
+    </TagDescriptions>
+  </DescribeTagsResult>
+</DescribeTagsResponse>`
+
+func TestELBTagLookup(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		fmt.Fprint(w, elbDescribeTagsResponse)
+	}))
+	defer ts.Close()
+
+	found, err := newELBTagLookup(testSession(ts.URL)).
+		LookupTags([]string{"my-lb"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "DescribeTags", form.Get("Action"))
+	assert.Equal(t, "my-lb", form.Get("LoadBalancerNames.member.1"))
+	assert.Equal(t, map[string]map[string]string{
+		"my-lb": {"env": "prod"},
+	}, found)
+}
+
+const rdsDescribeDBInstancesResponse = `<DescribeDBInstancesResponse xmlns="http://rds.amazonaws.com/doc/2014-10-31/">
+  <DescribeDBInstancesResult>
+    <DBInstances>
+      <DBInstance>
+        <DBInstanceIdentifier>orders</DBInstanceIdentifier>
+        <DBInstanceArn>arn:aws:rds:us-east-1:123456789012:db:orders</DBInstanceArn>
+      </DBInstance>
+    </DBInstances>
+  </DescribeDBInstancesResult>
+</DescribeDBInstancesResponse>`
+
+const rdsListTagsForResourceResponse = `<ListTagsForResourceResponse xmlns="http://rds.amazonaws.com/doc/2014-10-31/">
+  <ListTagsForResourceResult>
+    <TagList>
+      <Tag>
+        <Key>team</Key>
+        <Value>payments</Value>
+      </Tag>
+    </TagList>
+  </ListTagsForResourceResult>
+</ListTagsForResourceResponse>`
+
+const rdsNotFoundResponse = `<ErrorResponse xmlns="http://rds.amazonaws.com/doc/2014-10-31/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>DBInstanceNotFound</Code>
+    <Message>DBInstance gone not found.</Message>
+  </Error>
+  <RequestId>1</RequestId>
+</ErrorResponse>`
+
+func TestRDSTagLookup(t *testing.T) {
+	var forms []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		switch {
+		case r.PostForm.Get("DBInstanceIdentifier") == "gone":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, rdsNotFoundResponse)
+		case r.PostForm.Get("Action") == "DescribeDBInstances":
+			fmt.Fprint(w, rdsDescribeDBInstancesResponse)
+		default:
+			fmt.Fprint(w, rdsListTagsForResourceResponse)
+		}
+	}))
+	defer ts.Close()
+
+	found, err := newRDSTagLookup(testSession(ts.URL)).
+		LookupTags([]string{"orders", "gone"})
+	require.NoError(t, err)
+
+	require.Len(t, forms, 3)
+	assert.Equal(t, "DescribeDBInstances", forms[0].Get("Action"))
+	assert.Equal(t, "orders", forms[0].Get("DBInstanceIdentifier"))
+	assert.Equal(t, "ListTagsForResource", forms[1].Get("Action"))
+	assert.Equal(t, "arn:aws:rds:us-east-1:123456789012:db:orders",
+		forms[1].Get("ResourceName"))
+	assert.Equal(t, map[string]map[string]string{
+		"orders": {"team": "payments"},
+	}, found)
+}
@@ -0,0 +1,147 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/private/signer/v4"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+)
+
+// defaultMaxRetries is the number of times the collectors retry a failed or
+// throttled call.
+const defaultMaxRetries = 3
+
+// ServiceConfig holds the connection settings shared by the collectors of
+// AWS services other than CloudWatch.
+type ServiceConfig struct {
+	Region string
+	// EndpointURL overrides the service endpoint, e.g. to use a local
+	// server compatible with the service instead of AWS.
+	EndpointURL string `toml:"endpoint_url"`
+	// MaxRetries is the number of times a failed or throttled call is
+	// retried, with an exponential backoff.
+	MaxRetries int
+
+	internalaws.CredentialConfig
+}
+
+// session returns the session for the configured region, endpoint and
+// credentials.
+func (c *ServiceConfig) session() *session.Session {
+	maxRetries := c.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries
+	}
+	return c.RetryingSession(c.Region, c.EndpointURL, maxRetries)
+}
+
+// validate returns the problems with the connection settings.
+func (c *ServiceConfig) validate() []error {
+	var errs []error
+	if c.Region == "" {
+		errs = append(errs, fmt.Errorf("region is not set"))
+	}
+	if c.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("max_retries must not be negative"))
+	}
+	return errs
+}
+
+// newJSONClient returns a client for a service speaking the AWS JSON
+// protocol in jsonVersion, which the vendored SDK does not ship. Request and
+// response shapes are plain structs with json tags. Operations are sent to
+// targetPrefix.OperationName.
+func newJSONClient(
+	p client.ConfigProvider,
+	service, apiVersion, jsonVersion, targetPrefix string,
+) *client.Client {
+	c := p.ClientConfig(service)
+	svc := client.New(
+		*c.Config,
+		metadata.ClientInfo{
+			ServiceName:   service,
+			SigningRegion: c.SigningRegion,
+			Endpoint:      c.Endpoint,
+			APIVersion:    apiVersion,
+			JSONVersion:   jsonVersion,
+			TargetPrefix:  targetPrefix,
+		},
+		c.Handlers,
+	)
+
+	svc.Handlers.Sign.PushBack(v4.Sign)
+	svc.Handlers.Build.PushBack(jsonBuild)
+	svc.Handlers.Unmarshal.PushBack(jsonUnmarshal)
+	svc.Handlers.UnmarshalError.PushBack(jsonUnmarshalError)
+
+	return svc
+}
+
+func jsonBuild(r *request.Request) {
+	body := []byte("{}")
+	if r.ParamsFilled() {
+		b, err := json.Marshal(r.Params)
+		if err != nil {
+			r.Error = awserr.New("SerializationError",
+				"failed encoding JSON request", err)
+			return
+		}
+		body = b
+	}
+
+	r.SetBufferBody(body)
+	r.HTTPRequest.Header.Set("X-Amz-Target",
+		r.ClientInfo.TargetPrefix+"."+r.Operation.Name)
+	r.HTTPRequest.Header.Set("Content-Type",
+		"application/x-amz-json-"+r.ClientInfo.JSONVersion)
+}
+
+func jsonUnmarshal(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+	if r.DataFilled() {
+		if err := json.NewDecoder(r.HTTPResponse.Body).Decode(r.Data); err != nil {
+			r.Error = awserr.New("SerializationError",
+				"failed decoding JSON response", err)
+		}
+	}
+}
+
+type jsonErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// jsonUnmarshalError decodes JSON errors, whose type is the error code
+// prefixed with the namespace of the service, e.g.
+// com.amazonaws.dynamodb.v20120810#ResourceNotFoundException.
+func jsonUnmarshalError(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+
+	resp := &jsonErrorResponse{}
+	if err := json.NewDecoder(r.HTTPResponse.Body).Decode(resp); err != nil {
+		r.Error = awserr.NewRequestFailure(
+			awserr.New("SerializationError",
+				"failed decoding JSON error response", err),
+			r.HTTPResponse.StatusCode,
+			r.RequestID,
+		)
+		return
+	}
+
+	code := resp.Type
+	if i := strings.LastIndex(code, "#"); i >= 0 {
+		code = code[i+1:]
+	}
+	r.Error = awserr.NewRequestFailure(
+		awserr.New(code, resp.Message, nil),
+		r.HTTPResponse.StatusCode,
+		r.HTTPResponse.Header.Get("X-Amzn-Requestid"),
+	)
+}
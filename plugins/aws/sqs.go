@@ -0,0 +1,202 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// SQS reports the approximate message counts of SQS queues.
+type SQS struct {
+	ServiceConfig
+
+	// QueueNamePrefix only reports queues whose name starts with it.
+	QueueNamePrefix string
+
+	svc sqsAPI
+}
+
+// sqsAttributes maps the reported queue attributes to their field names.
+var sqsAttributes = map[string]string{
+	"ApproximateNumberOfMessages":           "approximate_number_of_messages",
+	"ApproximateNumberOfMessagesNotVisible": "approximate_number_of_messages_not_visible",
+	"ApproximateNumberOfMessagesDelayed":    "approximate_number_of_messages_delayed",
+}
+
+var sqsSampleConfig = `
+  # Region of the queues
+  region = "us-east-1"
+  # Only report queues whose name starts with this prefix
+  # queue_name_prefix = "prod-"
+
+  # Override the SQS endpoint, e.g. for a local mock server
+  # endpoint_url = "http://localhost:4576"
+  # Number of times a failed or throttled call is retried
+  # max_retries = 3
+
+  # Credentials, by default the SDK chain (environment, shared credentials
+  # file, EC2 instance role) is used
+  # access_key = ""
+  # secret_key = ""
+  # profile = ""
+  # role_arn = ""
+`
+
+func (s *SQS) SampleConfig() string {
+	return sqsSampleConfig
+}
+
+func (s *SQS) Description() string {
+	return "Read the approximate message counts of AWS SQS queues."
+}
+
+func (s *SQS) Validate() error {
+	var errs errorList
+	for _, err := range s.validate() {
+		errs.add(err)
+	}
+	return errs.err()
+}
+
+// Gather writes one point per queue. A queue that cannot be read does not
+// stop the others.
+func (s *SQS) Gather(acc plugins.Accumulator) error {
+	if s.svc == nil {
+		s.svc = newSQSClient(s.session())
+	}
+
+	input := &sqsListQueuesInput{}
+	if s.QueueNamePrefix != "" {
+		input.QueueNamePrefix = aws.String(s.QueueNamePrefix)
+	}
+	queues, err := s.svc.listQueues(input)
+	if err != nil {
+		return err
+	}
+
+	names := make([]*string, 0, len(sqsAttributes))
+	for name := range sqsAttributes {
+		names = append(names, aws.String(name))
+	}
+
+	var errs errorList
+	for _, url := range queues.QueueUrls {
+		resp, err := s.svc.getQueueAttributes(&sqsGetQueueAttributesInput{
+			QueueUrl:       url,
+			AttributeNames: names,
+		})
+		if err != nil {
+			errs.add(fmt.Errorf("%s: %s", *url, err))
+			continue
+		}
+
+		fields := make(map[string]interface{})
+		for _, attr := range resp.Attributes {
+			field, ok := sqsAttributes[aws.StringValue(attr.Name)]
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseInt(aws.StringValue(attr.Value), 10, 64)
+			if err != nil {
+				errs.add(fmt.Errorf("%s: attribute %s: %s",
+					*url, *attr.Name, err))
+				continue
+			}
+			fields[field] = value
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		tags := map[string]string{
+			"queue_name": queueName(*url),
+			"region":     s.Region,
+		}
+		acc.AddFields("queue", fields, tags)
+	}
+	return errs.err()
+}
+
+// queueName returns the name of the queue at url, its last path segment.
+func queueName(url string) string {
+	return url[strings.LastIndex(url, "/")+1:]
+}
+
+// The vendored SDK does not ship the SQS service, so the calls used by the
+// collector are declared here.
+
+type sqsListQueuesInput struct {
+	QueueNamePrefix *string `type:"string"`
+}
+
+type sqsListQueuesOutput struct {
+	QueueUrls []*string `locationNameList:"QueueUrl" type:"list" flattened:"true"`
+}
+
+type sqsGetQueueAttributesInput struct {
+	AttributeNames []*string `locationNameList:"AttributeName" type:"list" flattened:"true"`
+	QueueUrl       *string   `type:"string" required:"true"`
+}
+
+type sqsAttribute struct {
+	Name  *string `type:"string"`
+	Value *string `type:"string"`
+}
+
+type sqsGetQueueAttributesOutput struct {
+	Attributes []*sqsAttribute `locationName:"Attribute" type:"list" flattened:"true"`
+}
+
+// sqsAPI is the subset of SQS used by the collector.
+type sqsAPI interface {
+	listQueues(*sqsListQueuesInput) (*sqsListQueuesOutput, error)
+	getQueueAttributes(
+		*sqsGetQueueAttributesInput,
+	) (*sqsGetQueueAttributesOutput, error)
+}
+
+type sqsClient struct {
+	*client.Client
+}
+
+func newSQSClient(p client.ConfigProvider) *sqsClient {
+	return &sqsClient{internalaws.NewQueryClient(p, "sqs", "2012-11-05")}
+}
+
+func (c *sqsClient) listQueues(
+	input *sqsListQueuesInput,
+) (*sqsListQueuesOutput, error) {
+	op := &request.Operation{
+		Name:       "ListQueues",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &sqsListQueuesOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func (c *sqsClient) getQueueAttributes(
+	input *sqsGetQueueAttributesInput,
+) (*sqsGetQueueAttributesOutput, error) {
+	op := &request.Operation{
+		Name:       "GetQueueAttributes",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &sqsGetQueueAttributesOutput{}
+	req := c.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func init() {
+	plugins.Add("aws_sqs", func() plugins.Plugin { return &SQS{} })
+}
@@ -0,0 +1,130 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServiceConfig returns the settings to talk to a local stub.
+func testServiceConfig(endpoint string) ServiceConfig {
+	return ServiceConfig{
+		Region:      "us-east-1",
+		EndpointURL: endpoint,
+		CredentialConfig: internalaws.CredentialConfig{
+			AccessKey: "id",
+			SecretKey: "secret",
+		},
+	}
+}
+
+const sqsListQueuesResponse = `<ListQueuesResponse>
+  <ListQueuesResult>
+    <QueueUrl>https://queue.amazonaws.com/123456789012/prod-orders</QueueUrl>
+    <QueueUrl>https://queue.amazonaws.com/123456789012/prod-emails</QueueUrl>
+  </ListQueuesResult>
+</ListQueuesResponse>`
+
+const sqsGetQueueAttributesResponse = `<GetQueueAttributesResponse>
+  <GetQueueAttributesResult>
+    <Attribute>
+      <Name>ApproximateNumberOfMessages</Name>
+      <Value>%d</Value>
+    </Attribute>
+    <Attribute>
+      <Name>ApproximateNumberOfMessagesNotVisible</Name>
+      <Value>3</Value>
+    </Attribute>
+    <Attribute>
+      <Name>ApproximateNumberOfMessagesDelayed</Name>
+      <Value>0</Value>
+    </Attribute>
+  </GetQueueAttributesResult>
+</GetQueueAttributesResponse>`
+
+func TestSQS(t *testing.T) {
+	var forms []url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+		switch r.PostForm.Get("Action") {
+		case "ListQueues":
+			fmt.Fprint(w, sqsListQueuesResponse)
+		case "GetQueueAttributes":
+			messages := 12
+			if r.PostForm.Get("QueueUrl") ==
+				"https://queue.amazonaws.com/123456789012/prod-emails" {
+				messages = 7
+			}
+			fmt.Fprintf(w, sqsGetQueueAttributesResponse, messages)
+		}
+	}))
+	defer ts.Close()
+
+	s := &SQS{
+		ServiceConfig:   testServiceConfig(ts.URL),
+		QueueNamePrefix: "prod-",
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, s.Gather(&acc))
+
+	require.Len(t, forms, 3)
+	assert.Equal(t, "prod-", forms[0].Get("QueueNamePrefix"))
+	assert.NotEmpty(t, forms[1].Get("AttributeName.1"))
+
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.CheckTaggedFieldsValue("queue",
+		map[string]interface{}{
+			"approximate_number_of_messages":             int64(12),
+			"approximate_number_of_messages_not_visible": int64(3),
+			"approximate_number_of_messages_delayed":     int64(0),
+		},
+		map[string]string{"queue_name": "prod-orders", "region": "us-east-1"}))
+	assert.True(t, acc.CheckTaggedFieldsValue("queue",
+		map[string]interface{}{
+			"approximate_number_of_messages":             int64(7),
+			"approximate_number_of_messages_not_visible": int64(3),
+			"approximate_number_of_messages_delayed":     int64(0),
+		},
+		map[string]string{"queue_name": "prod-emails", "region": "us-east-1"}))
+}
+
+func TestSQSError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code>
+<Message>not allowed</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+	}))
+	defer ts.Close()
+
+	s := &SQS{ServiceConfig: testServiceConfig(ts.URL)}
+	err := s.Gather(&testutil.Accumulator{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "AccessDenied")
+}
+
+func TestServiceConfigValidate(t *testing.T) {
+	s := &SQS{ServiceConfig: ServiceConfig{MaxRetries: -1}}
+	err := s.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "region is not set")
+	assert.Contains(t, err.Error(), "max_retries must not be negative")
+
+	assert.NoError(t, (&SQS{ServiceConfig: testServiceConfig("")}).Validate())
+}
+
+func TestSessionCache(t *testing.T) {
+	a := testServiceConfig("http://localhost:1")
+	b := testServiceConfig("http://localhost:1")
+	c := testServiceConfig("http://localhost:2")
+
+	assert.True(t, a.session() == b.session())
+	assert.False(t, a.session() == c.session())
+}
@@ -0,0 +1,142 @@
+package aws
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStateAge is how long a series is remembered after its last datapoint,
+// so series that disappear (terminated instances, deleted queues) do not
+// accumulate forever.
+const maxStateAge = 7 * 24 * time.Hour
+
+// seriesState remembers the newest datapoint emitted for each series so that
+// overlapping gather windows do not emit the same datapoints twice.
+//
+// Datapoints are compared against the timestamps committed by the previous
+// gather, since CloudWatch does not return them in order.
+type seriesState struct {
+	sync.Mutex
+
+	path    string
+	last    map[string]time.Time
+	pending map[string]time.Time
+}
+
+// newSeriesState returns an empty state. If path is not empty the state is
+// loaded from and saved to that file.
+func newSeriesState(path string) *seriesState {
+	return &seriesState{
+		path:    path,
+		last:    make(map[string]time.Time),
+		pending: make(map[string]time.Time),
+	}
+}
+
+// seriesKey identifies a series by namespace, metric, dimensions and
+// statistic.
+func seriesKey(
+	namespace, metricName string,
+	tags map[string]string,
+	statistic string,
+) string {
+	dims := make([]string, 0, len(tags))
+	for k, v := range tags {
+		dims = append(dims, k+"="+v)
+	}
+	sort.Strings(dims)
+	return strings.Join([]string{
+		namespace, metricName, strings.Join(dims, ","), statistic}, "|")
+}
+
+// isNew returns true if t is newer than the last datapoint committed for
+// key, and records t to be committed.
+func (s *seriesState) isNew(key string, t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if !t.After(s.last[key]) {
+		return false
+	}
+	if t.After(s.pending[key]) {
+		s.pending[key] = t
+	}
+	return true
+}
+
+// commit makes the datapoints seen since the last commit the new baseline
+// and writes the state file, if any.
+func (s *seriesState) commit(now time.Time) error {
+	if s == nil {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	for key, t := range s.pending {
+		s.last[key] = t
+	}
+	s.pending = make(map[string]time.Time)
+
+	for key, t := range s.last {
+		if now.Sub(t) > maxStateAge {
+			delete(s.last, key)
+		}
+	}
+
+	if s.path == "" {
+		return nil
+	}
+	return s.save()
+}
+
+// load reads the state file. A missing file is not an error.
+func (s *seriesState) load() error {
+	if s.path == "" {
+		return nil
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.last)
+}
+
+// save writes the state to a temporary file and renames it into place so a
+// crash never leaves a truncated state file behind.
+func (s *seriesState) save() error {
+	data, err := json.Marshal(s.last)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,104 @@
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesStateIsNew(t *testing.T) {
+	s := newSeriesState("")
+	now := time.Now()
+	key := seriesKey("AWS/EC2", "CPUUtilization",
+		map[string]string{"InstanceId": "i-abc"}, "Average")
+
+	// Datapoints arrive unordered within one gather
+	assert.True(t, s.isNew(key, now))
+	assert.True(t, s.isNew(key, now.Add(-time.Minute)))
+	require.NoError(t, s.commit(now))
+
+	assert.False(t, s.isNew(key, now.Add(-time.Minute)))
+	assert.False(t, s.isNew(key, now))
+	assert.True(t, s.isNew(key, now.Add(time.Minute)))
+
+	other := seriesKey("AWS/EC2", "CPUUtilization",
+		map[string]string{"InstanceId": "i-abc"}, "Maximum")
+	assert.True(t, s.isNew(other, now))
+}
+
+func TestSeriesStatePrune(t *testing.T) {
+	s := newSeriesState("")
+	now := time.Now()
+
+	assert.True(t, s.isNew("old", now.Add(-maxStateAge-time.Hour)))
+	assert.True(t, s.isNew("new", now))
+	require.NoError(t, s.commit(now))
+
+	assert.Equal(t, 1, len(s.last))
+	_, ok := s.last["new"]
+	assert.True(t, ok)
+}
+
+func TestSeriesStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telegraf-cloudwatch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	now := time.Now().Round(time.Second)
+	s := newSeriesState(path)
+	require.NoError(t, s.load())
+	assert.True(t, s.isNew("key", now))
+	require.NoError(t, s.commit(now))
+
+	restarted := newSeriesState(path)
+	require.NoError(t, restarted.load())
+	assert.False(t, restarted.isNew("key", now))
+	assert.True(t, restarted.isNew("key", now.Add(time.Minute)))
+}
+
+func TestGatherDeduplicates(t *testing.T) {
+	// Answer every gather with the same two recent datapoints
+	now := time.Now().UTC().Truncate(time.Minute)
+	response := strings.NewReplacer(
+		"2015-11-20T10:00:00Z", now.Add(-2*time.Minute).Format(iso8601),
+		"2015-11-20T10:01:00Z", now.Add(-time.Minute).Format(iso8601),
+	).Replace(getMetricDataResponse)
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprint(w, response)
+	}))
+	defer ts.Close()
+
+	m := Metric{
+		Region:      "us-east-1",
+		Namespace:   "AWS/EC2",
+		Prefix:      "ec2",
+		MetricNames: []string{"CPUUtilization"},
+		Statistics:  []string{"Average"},
+		Period:      60,
+		Duration:    300,
+		Dimensions:  map[string]DimensionValues{"InstanceId": {"i-abc"}},
+	}
+	m.AccessKey = "AKID"
+	m.SecretKey = "SECRET"
+	cw := &CloudWatch{EndpointURL: ts.URL, Metrics: []Metric{m}}
+
+	var acc testutil.Accumulator
+	require.NoError(t, cw.Gather(&acc))
+	require.NoError(t, cw.Gather(&acc))
+
+	assert.Equal(t, 2, calls)
+	assert.Len(t, acc.Points, 2)
+}
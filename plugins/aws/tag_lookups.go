@@ -0,0 +1,299 @@
+package aws
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+)
+
+// newTagLookups returns the lookups for the dimensions whose resources can
+// be tagged.
+func newTagLookups(p client.ConfigProvider) map[string]TagLookup {
+	return map[string]TagLookup{
+		"InstanceId":           newEC2TagLookup(p),
+		"LoadBalancerName":     newELBTagLookup(p),
+		"DBInstanceIdentifier": newRDSTagLookup(p),
+	}
+}
+
+// The vendored SDK does not ship the EC2, ELB and RDS services, so the calls
+// used to look up resource tags are declared here.
+
+const opDescribeTags = "DescribeTags"
+
+// maxEC2FilterValues is the number of values an EC2 filter accepts.
+const maxEC2FilterValues = 200
+
+type ec2Filter struct {
+	Name   *string   `type:"string"`
+	Values []*string `locationName:"Value" type:"list" flattened:"true"`
+}
+
+type ec2DescribeTagsInput struct {
+	Filters   []*ec2Filter `locationName:"Filter" type:"list" flattened:"true"`
+	NextToken *string      `type:"string"`
+}
+
+type ec2TagDescription struct {
+	Key        *string `locationName:"key" type:"string"`
+	ResourceId *string `locationName:"resourceId" type:"string"`
+	Value      *string `locationName:"value" type:"string"`
+}
+
+type ec2DescribeTagsOutput struct {
+	NextToken *string              `locationName:"nextToken" type:"string"`
+	Tags      []*ec2TagDescription `locationName:"tagSet" locationNameList:"item" type:"list"`
+}
+
+// ec2TagLookup looks up the tags of EC2 instances.
+type ec2TagLookup struct {
+	*client.Client
+}
+
+func newEC2TagLookup(p client.ConfigProvider) *ec2TagLookup {
+	svc := internalaws.NewQueryClient(p, "ec2", "2015-10-01")
+	svc.Handlers.UnmarshalError.Clear()
+	svc.Handlers.UnmarshalError.PushBack(ec2UnmarshalError)
+	return &ec2TagLookup{svc}
+}
+
+func (l *ec2TagLookup) describeTags(
+	input *ec2DescribeTagsInput,
+) (*ec2DescribeTagsOutput, error) {
+	op := &request.Operation{
+		Name:       opDescribeTags,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &ec2DescribeTagsOutput{}
+	req := l.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func (l *ec2TagLookup) LookupTags(
+	ids []string,
+) (map[string]map[string]string, error) {
+	found := make(map[string]map[string]string)
+	for offset := 0; offset < len(ids); offset += maxEC2FilterValues {
+		limit := offset + maxEC2FilterValues
+		if limit > len(ids) {
+			limit = len(ids)
+		}
+
+		input := &ec2DescribeTagsInput{
+			Filters: []*ec2Filter{{
+				Name:   aws.String("resource-id"),
+				Values: aws.StringSlice(ids[offset:limit]),
+			}},
+		}
+		for {
+			resp, err := l.describeTags(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, tag := range resp.Tags {
+				id := aws.StringValue(tag.ResourceId)
+				if found[id] == nil {
+					found[id] = make(map[string]string)
+				}
+				found[id][aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			if aws.StringValue(resp.NextToken) == "" {
+				break
+			}
+			input.NextToken = resp.NextToken
+		}
+	}
+	return found, nil
+}
+
+type ec2ErrorResponse struct {
+	Code      string `xml:"Errors>Error>Code"`
+	Message   string `xml:"Errors>Error>Message"`
+	RequestID string `xml:"RequestID"`
+}
+
+// ec2UnmarshalError decodes EC2 errors, which are not wrapped in an
+// ErrorResponse element like those of other query services.
+func ec2UnmarshalError(r *request.Request) {
+	defer r.HTTPResponse.Body.Close()
+
+	resp := &ec2ErrorResponse{}
+	err := xml.NewDecoder(r.HTTPResponse.Body).Decode(resp)
+	if err != nil && err != io.EOF {
+		r.Error = awserr.New("SerializationError",
+			"failed to decode EC2 XML error response", err)
+		return
+	}
+	r.Error = awserr.NewRequestFailure(
+		awserr.New(resp.Code, resp.Message, nil),
+		r.HTTPResponse.StatusCode,
+		resp.RequestID,
+	)
+}
+
+// maxELBNames is the number of load balancers ELB DescribeTags accepts.
+const maxELBNames = 20
+
+type elbDescribeTagsInput struct {
+	LoadBalancerNames []*string `type:"list" required:"true"`
+}
+
+type elbTag struct {
+	Key   *string `type:"string"`
+	Value *string `type:"string"`
+}
+
+type elbTagDescription struct {
+	LoadBalancerName *string   `type:"string"`
+	Tags             []*elbTag `type:"list"`
+}
+
+type elbDescribeTagsOutput struct {
+	TagDescriptions []*elbTagDescription `type:"list"`
+}
+
+// elbTagLookup looks up the tags of classic load balancers.
+type elbTagLookup struct {
+	*client.Client
+}
+
+func newELBTagLookup(p client.ConfigProvider) *elbTagLookup {
+	return &elbTagLookup{
+		internalaws.NewQueryClient(p, "elasticloadbalancing", "2012-06-01"),
+	}
+}
+
+func (l *elbTagLookup) describeTags(
+	input *elbDescribeTagsInput,
+) (*elbDescribeTagsOutput, error) {
+	op := &request.Operation{
+		Name:       opDescribeTags,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &elbDescribeTagsOutput{}
+	req := l.NewRequest(op, input, output)
+	err := req.Send()
+	return output, err
+}
+
+func (l *elbTagLookup) LookupTags(
+	names []string,
+) (map[string]map[string]string, error) {
+	found := make(map[string]map[string]string)
+	for offset := 0; offset < len(names); offset += maxELBNames {
+		limit := offset + maxELBNames
+		if limit > len(names) {
+			limit = len(names)
+		}
+
+		resp, err := l.describeTags(&elbDescribeTagsInput{
+			LoadBalancerNames: aws.StringSlice(names[offset:limit]),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range resp.TagDescriptions {
+			tags := make(map[string]string)
+			for _, tag := range desc.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			found[aws.StringValue(desc.LoadBalancerName)] = tags
+		}
+	}
+	return found, nil
+}
+
+const (
+	opDescribeDBInstances = "DescribeDBInstances"
+	opListTagsForResource = "ListTagsForResource"
+)
+
+type rdsDescribeDBInstancesInput struct {
+	DBInstanceIdentifier *string `type:"string"`
+}
+
+type rdsDBInstance struct {
+	DBInstanceArn        *string `type:"string"`
+	DBInstanceIdentifier *string `type:"string"`
+}
+
+type rdsDescribeDBInstancesOutput struct {
+	DBInstances []*rdsDBInstance `locationNameList:"DBInstance" type:"list"`
+}
+
+type rdsListTagsForResourceInput struct {
+	ResourceName *string `type:"string" required:"true"`
+}
+
+type rdsTag struct {
+	Key   *string `type:"string"`
+	Value *string `type:"string"`
+}
+
+type rdsListTagsForResourceOutput struct {
+	TagList []*rdsTag `locationNameList:"Tag" type:"list"`
+}
+
+// rdsTagLookup looks up the tags of RDS instances. Tags are listed by ARN,
+// which is read from the instance first, so every instance takes two calls.
+type rdsTagLookup struct {
+	*client.Client
+}
+
+func newRDSTagLookup(p client.ConfigProvider) *rdsTagLookup {
+	return &rdsTagLookup{internalaws.NewQueryClient(p, "rds", "2014-10-31")}
+}
+
+func (l *rdsTagLookup) send(name string, input, output interface{}) error {
+	op := &request.Operation{
+		Name:       name,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	return l.NewRequest(op, input, output).Send()
+}
+
+func (l *rdsTagLookup) LookupTags(
+	ids []string,
+) (map[string]map[string]string, error) {
+	found := make(map[string]map[string]string)
+	for _, id := range ids {
+		instances := &rdsDescribeDBInstancesOutput{}
+		err := l.send(opDescribeDBInstances,
+			&rdsDescribeDBInstancesInput{DBInstanceIdentifier: aws.String(id)},
+			instances)
+		if awsErr, ok := err.(awserr.Error); ok &&
+			awsErr.Code() == "DBInstanceNotFound" {
+			// Deleted instances keep their metrics for a while
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(instances.DBInstances) == 0 {
+			continue
+		}
+
+		resp := &rdsListTagsForResourceOutput{}
+		err = l.send(opListTagsForResource, &rdsListTagsForResourceInput{
+			ResourceName: instances.DBInstances[0].DBInstanceArn,
+		}, resp)
+		if err != nil {
+			return nil, err
+		}
+		tags := make(map[string]string)
+		for _, tag := range resp.TagList {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		found[id] = tags
+	}
+	return found, nil
+}
@@ -0,0 +1,316 @@
+package aws
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+const (
+	// defaultConcurrency is the number of API calls in flight when the
+	// plugin sets no Concurrency.
+	defaultConcurrency = 4
+	// defaultRateLimit is the number of API calls per second when the plugin
+	// sets no RateLimit.
+	defaultRateLimit = 20
+
+	// maxRetries is how often a throttled or failed call is retried before
+	// its error is returned. The SDK does not retry these calls itself.
+	maxRetries = 5
+	// minBackoff and maxBackoff bound the wait between retries. The wait
+	// doubles with every retry.
+	minBackoff = 200 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// isThrottled returns true if err means AWS rejected the call because too
+// many requests were made.
+func isThrottled(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "Throttling", "ThrottlingException", "RequestLimitExceeded":
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryable returns true if err may go away by making the call again:
+// throttling, server errors and failed connections, like the SDK retries.
+func isRetryable(err error) bool {
+	if isThrottled(err) {
+		return true
+	}
+	if isBatchUnsupported(err) {
+		return false
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() >= 500
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code() == "RequestError"
+	}
+	return false
+}
+
+// rateLimiter spaces out calls so that no more than one call per interval
+// is started.
+type rateLimiter struct {
+	sync.Mutex
+
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter allowing perSecond calls per second.
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// reserve books the next free slot and returns how long the caller has to
+// wait, at time now, before making its call.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return wait
+}
+
+// throttledClient wraps a CloudWatch client, rate limiting every call and
+// retrying calls rejected with a throttling or server error using
+// exponential backoff. It is the only retry layer: the wrapped client's
+// session does not retry, so every attempt is rate limited and recorded.
+type throttledClient struct {
+	cloudwatchiface.CloudWatchAPI
+
+	limiter *rateLimiter
+	// sleep waits for the given duration, replaced in tests.
+	sleep func(time.Duration)
+
+	// usage records every call made in region, if set.
+	usage  *usageRecorder
+	region string
+}
+
+func newThrottledClient(
+	svc cloudwatchiface.CloudWatchAPI,
+	limiter *rateLimiter,
+) *throttledClient {
+	return &throttledClient{
+		CloudWatchAPI: svc,
+		limiter:       limiter,
+		sleep:         time.Sleep,
+	}
+}
+
+// call runs fn once a rate limit slot is free, retrying it while it fails
+// with a retryable error. Every attempt is recorded as a call for
+// namespace.
+func (c *throttledClient) call(namespace string, fn func() error) error {
+	key := usageKey{namespace, c.region}
+	backoff := minBackoff
+	for retry := 0; ; retry++ {
+		if wait := c.limiter.reserve(time.Now()); wait > 0 {
+			c.sleep(wait)
+		}
+
+		start := time.Now()
+		err := fn()
+		c.usage.call(key, time.Since(start), err)
+		if !isRetryable(err) || retry == maxRetries {
+			return err
+		}
+
+		// Wait between half and all of the backoff, so that throttled
+		// workers do not all retry at the same moment.
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		printDebug("call failed, retrying in ", wait, ": ", err)
+		c.sleep(wait)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *throttledClient) GetMetricStatistics(
+	params *cloudwatch.GetMetricStatisticsInput,
+) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	var resp *cloudwatch.GetMetricStatisticsOutput
+	namespace := aws.StringValue(params.Namespace)
+	err := c.call(namespace, func() error {
+		var err error
+		resp, err = c.CloudWatchAPI.GetMetricStatistics(params)
+		return err
+	})
+	if err == nil {
+		c.usage.received(usageKey{namespace, c.region}, 1, len(resp.Datapoints))
+	}
+	return resp, err
+}
+
+// ListMetricsPages reads all pages before handing them to fn, so a retry
+// after a throttled page does not pass the earlier pages twice. Reading all
+// pages is recorded as one call, but every page as a billed request.
+func (c *throttledClient) ListMetricsPages(
+	params *cloudwatch.ListMetricsInput,
+	fn func(*cloudwatch.ListMetricsOutput, bool) bool,
+) error {
+	var pages []*cloudwatch.ListMetricsOutput
+	namespace := aws.StringValue(params.Namespace)
+	err := c.call(namespace, func() error {
+		pages = nil
+		return c.CloudWatchAPI.ListMetricsPages(params,
+			func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+				pages = append(pages, page)
+				return true
+			})
+	})
+	if err != nil {
+		return err
+	}
+	c.usage.received(usageKey{namespace, c.region}, len(pages), 0)
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// DescribeAlarmsPages reads all pages before handing them to fn, like
+// ListMetricsPages.
+func (c *throttledClient) DescribeAlarmsPages(
+	params *cloudwatch.DescribeAlarmsInput,
+	fn func(*cloudwatch.DescribeAlarmsOutput, bool) bool,
+) error {
+	var pages []*cloudwatch.DescribeAlarmsOutput
+	err := c.call("", func() error {
+		pages = nil
+		return c.CloudWatchAPI.DescribeAlarmsPages(params,
+			func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+				pages = append(pages, page)
+				return true
+			})
+	})
+	if err != nil {
+		return err
+	}
+	c.usage.received(usageKey{"", c.region}, len(pages), 0)
+
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+// GetMetricData reports the call as not implemented if the wrapped client
+// cannot serve it, so the caller falls back to GetMetricStatistics.
+func (c *throttledClient) GetMetricData(
+	params *getMetricDataInput,
+) (*getMetricDataOutput, error) {
+	batcher, ok := c.CloudWatchAPI.(metricDataAPI)
+	if !ok {
+		return nil, awserr.New("NotImplemented",
+			"client does not support GetMetricData", nil)
+	}
+
+	var namespace string
+	if len(params.MetricDataQueries) > 0 {
+		if stat := params.MetricDataQueries[0].MetricStat; stat != nil {
+			namespace = aws.StringValue(stat.Metric.Namespace)
+		}
+	}
+
+	var resp *getMetricDataOutput
+	err := c.call(namespace, func() error {
+		var err error
+		resp, err = batcher.GetMetricData(params)
+		return err
+	})
+	if err == nil {
+		datapoints := 0
+		for _, result := range resp.MetricDataResults {
+			datapoints += len(result.Values)
+		}
+		c.usage.received(usageKey{namespace, c.region},
+			len(params.MetricDataQueries), datapoints)
+	}
+	return resp, err
+}
+
+// workerPool bounds the number of API calls in flight across all metric
+// blocks. A nil pool runs every job right away in the calling goroutine.
+type workerPool chan struct{}
+
+// run calls fn on its own goroutine once a worker is free and registers it
+// with wg.
+func (p workerPool) run(wg *sync.WaitGroup, fn func()) {
+	if p == nil {
+		fn()
+		return
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p <- struct{}{}
+		defer func() { <-p }()
+		fn()
+	}()
+}
+
+// errorList collects the errors of jobs running concurrently.
+type errorList struct {
+	sync.Mutex
+	errs []error
+}
+
+// add records err, ignoring nil errors.
+func (l *errorList) add(err error) {
+	if err == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.errs = append(l.errs, err)
+}
+
+// err returns nil if no error was recorded, the error itself if there was
+// one and an error listing all of them otherwise.
+func (l *errorList) err() error {
+	l.Lock()
+	defer l.Unlock()
+
+	switch len(l.errs) {
+	case 0:
+		return nil
+	case 1:
+		return l.errs[0]
+	}
+
+	msgs := make([]string, len(l.errs))
+	for i, err := range l.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%d errors: %s", len(l.errs), strings.Join(msgs, "; "))
+}
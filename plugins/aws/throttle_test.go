@@ -0,0 +1,273 @@
+package aws
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	internalaws "github.com/influxdb/telegraf/internal/aws"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// throttlingClient rejects the first throttled calls per metric with a
+// throttling error and always fails for the metrics listed in failing.
+type throttlingClient struct {
+	mockCloudWatchClient
+
+	sync.Mutex
+	throttled int
+	failing   map[string]bool
+	calls     map[string]int
+}
+
+func (c *throttlingClient) GetMetricStatistics(
+	params *cloudwatch.GetMetricStatisticsInput,
+) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	name := *params.MetricName
+	if c.calls == nil {
+		c.calls = make(map[string]int)
+	}
+	c.calls[name]++
+	if c.failing[name] {
+		return nil, awserr.New("AccessDenied", "not allowed", nil)
+	}
+	if c.calls[name] <= c.throttled {
+		return nil, awserr.New("Throttling", "rate exceeded", nil)
+	}
+	return &cloudwatch.GetMetricStatisticsOutput{
+		Label: params.MetricName,
+		Datapoints: []*cloudwatch.Datapoint{
+			{
+				Sum:       aws.Float64(3),
+				Timestamp: aws.Time(params.EndTime.Add(-time.Minute)),
+			},
+		},
+	}, nil
+}
+
+// sleepRecorder returns a sleep function recording the waits instead of
+// sleeping.
+func sleepRecorder(waits *[]time.Duration) func(time.Duration) {
+	var mu sync.Mutex
+	return func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		*waits = append(*waits, d)
+	}
+}
+
+func TestThrottledClientRetries(t *testing.T) {
+	fake := &throttlingClient{throttled: 3}
+	var waits []time.Duration
+	svc := newThrottledClient(fake, nil)
+	svc.sleep = sleepRecorder(&waits)
+
+	resp, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		MetricName: aws.String("RequestCount"),
+		EndTime:    aws.Time(time.Now()),
+	})
+	require.NoError(t, err)
+	assert.Len(t, resp.Datapoints, 1)
+
+	assert.Equal(t, 4, fake.calls["RequestCount"])
+	require.Len(t, waits, 3)
+	for i, wait := range waits {
+		backoff := minBackoff << uint(i)
+		assert.True(t, wait >= backoff/2 && wait < backoff,
+			"wait %d out of range: %s", i, wait)
+	}
+}
+
+func TestThrottledClientGivesUp(t *testing.T) {
+	fake := &throttlingClient{throttled: maxRetries + 10}
+	var waits []time.Duration
+	svc := newThrottledClient(fake, nil)
+	svc.sleep = sleepRecorder(&waits)
+
+	_, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		MetricName: aws.String("RequestCount"),
+		EndTime:    aws.Time(time.Now()),
+	})
+	require.Error(t, err)
+	assert.True(t, isThrottled(err))
+	assert.Equal(t, maxRetries+1, fake.calls["RequestCount"])
+}
+
+func TestThrottledClientDoesNotRetryOtherErrors(t *testing.T) {
+	fake := &throttlingClient{failing: map[string]bool{"RequestCount": true}}
+	svc := newThrottledClient(fake, nil)
+	svc.sleep = func(time.Duration) { t.Fatal("unexpected sleep") }
+
+	_, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		MetricName: aws.String("RequestCount"),
+	})
+	require.Error(t, err)
+	assert.Equal(t, 1, fake.calls["RequestCount"])
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(awserr.New("Throttling", "rate exceeded", nil)))
+	assert.True(t, isRetryable(awserr.NewRequestFailure(
+		awserr.New("InternalFailure", "try again", nil), 500, "1")))
+	assert.True(t, isRetryable(awserr.New("RequestError", "connection reset",
+		nil)))
+
+	assert.False(t, isRetryable(nil))
+	assert.False(t, isRetryable(awserr.NewRequestFailure(
+		awserr.New("AccessDenied", "not allowed", nil), 403, "1")))
+	assert.False(t, isRetryable(awserr.NewRequestFailure(
+		awserr.New("NotImplemented", "no GetMetricData", nil), 501, "1")))
+}
+
+func TestClientSessionDoesNotRetry(t *testing.T) {
+	cw := &CloudWatch{}
+	svc := cw.client("us-east-1", internalaws.CredentialConfig{AccessKey: "AKID",
+		SecretKey: "SECRET"}).(*throttledClient)
+	batcher := svc.CloudWatchAPI.(*metricDataClient)
+	assert.Equal(t, 0, batcher.Client.Retryer.MaxRetries())
+}
+
+func TestThrottledClientGetMetricDataUnsupported(t *testing.T) {
+	svc := newThrottledClient(&mockCloudWatchClient{}, nil)
+
+	_, err := svc.GetMetricData(&getMetricDataInput{})
+	assert.True(t, isBatchUnsupported(err))
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(4)
+	now := time.Now()
+
+	assert.Equal(t, time.Duration(0), l.reserve(now))
+	assert.Equal(t, 250*time.Millisecond, l.reserve(now))
+	assert.Equal(t, 500*time.Millisecond, l.reserve(now))
+
+	// Slots not used while idle are not saved up
+	later := now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), l.reserve(later))
+	assert.Equal(t, 250*time.Millisecond, l.reserve(later))
+}
+
+func TestGatherReportsAllErrors(t *testing.T) {
+	fake := &throttlingClient{
+		throttled: 1,
+		failing: map[string]bool{
+			"HTTPCode_Backend_5XX": true,
+			"SurgeQueueLength":     true,
+		},
+	}
+	svc := newThrottledClient(fake, nil)
+	svc.sleep = func(time.Duration) {}
+
+	cw := &CloudWatch{
+		Concurrency: 2,
+		Metrics: []Metric{{
+			Namespace: "AWS/ELB",
+			Prefix:    "elb",
+			MetricNames: []string{
+				"RequestCount",
+				"HTTPCode_Backend_5XX",
+				"SurgeQueueLength",
+				"HealthyHostCount",
+			},
+			Statistics: []string{"Sum"},
+			Period:     60,
+			Duration:   60,
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{{}: svc},
+	}
+
+	var acc testutil.Accumulator
+	err := cw.Gather(&acc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 errors")
+	assert.Contains(t, err.Error(), "HTTPCode_Backend_5XX")
+	assert.Contains(t, err.Error(), "SurgeQueueLength")
+
+	require.Len(t, acc.Points, 2)
+	assert.True(t, acc.HasMeasurement("elb_RequestCount"))
+	assert.True(t, acc.HasMeasurement("elb_HealthyHostCount"))
+}
+
+func TestErrorList(t *testing.T) {
+	var errs errorList
+	assert.NoError(t, errs.err())
+
+	errs.add(nil)
+	assert.NoError(t, errs.err())
+
+	first := errors.New("first")
+	errs.add(first)
+	assert.Equal(t, first, errs.err())
+
+	errs.add(errors.New("second"))
+	assert.EqualError(t, errs.err(), "2 errors: first; second")
+}
+
+func TestGatherReportsAPIUsage(t *testing.T) {
+	usage := newUsageRecorder()
+	fake := &throttlingClient{
+		throttled: 1,
+		failing:   map[string]bool{"SurgeQueueLength": true},
+	}
+	svc := newThrottledClient(fake, nil)
+	svc.sleep = func(time.Duration) {}
+	svc.usage = usage
+	svc.region = "us-east-1"
+
+	cw := &CloudWatch{
+		ReportAPIUsage: true,
+		Metrics: []Metric{{
+			Region:      "us-east-1",
+			Namespace:   "AWS/ELB",
+			Prefix:      "elb",
+			MetricNames: []string{"RequestCount", "SurgeQueueLength"},
+			Statistics:  []string{"Sum"},
+			Period:      60,
+			Duration:    60,
+		}},
+		clients: map[clientKey]cloudwatchiface.CloudWatchAPI{
+			{region: "us-east-1"}: svc,
+		},
+		usage: usage,
+	}
+
+	var acc testutil.Accumulator
+	require.Error(t, cw.Gather(&acc))
+
+	require.Len(t, acc.Points, 2)
+	p := acc.Points[1]
+	assert.Equal(t, "api_usage", p.Measurement)
+	assert.Equal(t, map[string]string{
+		"namespace": "AWS/ELB",
+		"region":    "us-east-1",
+	}, p.Tags)
+	assert.Equal(t, int64(3), p.Fields["calls"])
+	assert.Equal(t, int64(1), p.Fields["throttled_calls"])
+	assert.Equal(t, int64(1), p.Fields["errors"])
+	assert.Equal(t, int64(1), p.Fields["requests"])
+	assert.Equal(t, int64(1), p.Fields["datapoints"])
+	for _, field := range []string{"latency_mean_ms", "latency_max_ms"} {
+		_, ok := p.Fields[field]
+		assert.True(t, ok, field)
+	}
+
+	// Counting starts anew with every gather, RequestCount is not written
+	// again as it has no new datapoint
+	acc = testutil.Accumulator{}
+	fake.failing = nil
+	require.NoError(t, cw.Gather(&acc))
+	require.Len(t, acc.Points, 2)
+	assert.Equal(t, "api_usage", acc.Points[1].Measurement)
+	assert.Equal(t, int64(2), acc.Points[1].Fields["calls"])
+}
@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// usageKey identifies the API usage of a namespace in a region.
+type usageKey struct {
+	namespace string
+	region    string
+}
+
+// usage counts the API calls made for one namespace and region.
+type usage struct {
+	// calls counts every request sent, including retries and pages.
+	calls      int64
+	throttled  int64
+	errors     int64
+	datapoints int64
+	// requests estimates the billed requests: one per successful call,
+	// except for GetMetricData which is billed per metric requested.
+	requests int64
+
+	latency    time.Duration
+	maxLatency time.Duration
+}
+
+// usageRecorder collects the API usage of all clients between two reports.
+// A nil recorder records nothing.
+type usageRecorder struct {
+	sync.Mutex
+	usage map[usageKey]*usage
+}
+
+func newUsageRecorder() *usageRecorder {
+	return &usageRecorder{usage: make(map[usageKey]*usage)}
+}
+
+// call records a request that took latency and failed with err, if not nil.
+func (r *usageRecorder) call(key usageKey, latency time.Duration, err error) {
+	r.record(key, func(u *usage) {
+		u.calls++
+		u.latency += latency
+		if latency > u.maxLatency {
+			u.maxLatency = latency
+		}
+		switch {
+		case isThrottled(err):
+			u.throttled++
+		case err != nil:
+			u.errors++
+		}
+	})
+}
+
+// received records a successful call billed as requests, which returned
+// datapoints.
+func (r *usageRecorder) received(key usageKey, requests, datapoints int) {
+	r.record(key, func(u *usage) {
+		u.requests += int64(requests)
+		u.datapoints += int64(datapoints)
+	})
+}
+
+func (r *usageRecorder) record(key usageKey, fn func(*usage)) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+
+	u, ok := r.usage[key]
+	if !ok {
+		u = &usage{}
+		r.usage[key] = u
+	}
+	fn(u)
+}
+
+// write adds one api_usage point per namespace and region used since the
+// last report and starts counting anew.
+func (r *usageRecorder) write(acc plugins.Accumulator) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	recorded := r.usage
+	r.usage = make(map[usageKey]*usage)
+	r.Unlock()
+
+	for key, u := range recorded {
+		fields := map[string]interface{}{
+			"calls":           u.calls,
+			"throttled_calls": u.throttled,
+			"errors":          u.errors,
+			"datapoints":      u.datapoints,
+			"requests":        u.requests,
+			"latency_max_ms":  durationMillis(u.maxLatency),
+		}
+		if u.calls > 0 {
+			fields["latency_mean_ms"] = durationMillis(
+				u.latency / time.Duration(u.calls))
+		}
+
+		tags := map[string]string{"region": key.region}
+		if key.namespace != "" {
+			tags["namespace"] = key.namespace
+		}
+		acc.AddFields("api_usage", fields, tags)
+	}
+}
+
+// durationMillis returns d in milliseconds.
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,117 @@
+package aws
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// highResolutionPeriods are the periods below a minute CloudWatch accepts
+// for high-resolution metrics.
+var highResolutionPeriods = map[int64]bool{1: true, 5: true, 10: true, 30: true}
+
+// percentilePattern matches extended statistics such as p50 or p99.9.
+var percentilePattern = regexp.MustCompile(`^p(\d+(\.\d+)?)$`)
+
+// Validate checks every metric block and returns all problems found, so they
+// can be fixed before the agent starts.
+func (cw *CloudWatch) Validate() error {
+	var errs errorList
+	if cw.Concurrency < 0 {
+		errs.add(fmt.Errorf("concurrency must not be negative"))
+	}
+	if cw.RateLimit < 0 {
+		errs.add(fmt.Errorf("rate_limit must not be negative"))
+	}
+	if len(cw.Metrics) == 0 && len(cw.Alarms) == 0 {
+		errs.add(fmt.Errorf(
+			"no [[cloudwatch.metrics]] or [[cloudwatch.alarms]] configured"))
+	}
+	for i := range cw.Metrics {
+		for _, err := range cw.Metrics[i].validate() {
+			errs.add(fmt.Errorf("metrics[%d]: %s", i, err))
+		}
+	}
+	for i := range cw.Alarms {
+		for _, err := range cw.Alarms[i].validate() {
+			errs.add(fmt.Errorf("alarms[%d]: %s", i, err))
+		}
+	}
+	return errs.err()
+}
+
+// validate returns the problems with the configuration of a metric block.
+func (m *Metric) validate() []error {
+	var errs []error
+
+	if m.Region == "" && len(m.Regions) == 0 {
+		errs = append(errs, fmt.Errorf("region is not set"))
+	}
+	for _, region := range m.Regions {
+		if region == "" {
+			errs = append(errs, fmt.Errorf("regions contains an empty region"))
+		}
+	}
+	for name, values := range m.Dimensions {
+		if len(values) == 0 {
+			errs = append(errs, fmt.Errorf("dimension %s has no values", name))
+		}
+		for _, value := range values {
+			if _, err := path.Match(value, ""); err != nil {
+				errs = append(errs, fmt.Errorf(
+					"dimension %s: bad pattern %q", name, value))
+			}
+		}
+	}
+	if m.Namespace == "" {
+		errs = append(errs, fmt.Errorf("namespace is not set"))
+	}
+	errs = append(errs, m.validateMeasurementName()...)
+
+	if len(m.Statistics) == 0 && len(m.ExtendedStatistics) == 0 {
+		errs = append(errs, fmt.Errorf("no statistics configured"))
+	}
+	for _, statistic := range m.Statistics {
+		if _, ok := statisticFields[statistic]; !ok {
+			errs = append(errs, fmt.Errorf(
+				"unknown statistic %q, expected one of Average, Maximum, "+
+					"Minimum, Sum, SampleCount", statistic))
+		}
+	}
+	for _, statistic := range m.ExtendedStatistics {
+		if !isPercentile(statistic) {
+			errs = append(errs, fmt.Errorf(
+				"unknown extended statistic %q, expected a percentile such as p99",
+				statistic))
+		}
+	}
+
+	switch {
+	case m.Period <= 0:
+		errs = append(errs, fmt.Errorf("period must be positive"))
+	case m.Period%60 != 0 && !highResolutionPeriods[m.Period]:
+		errs = append(errs, fmt.Errorf(
+			"period %d is not a multiple of 60 or one of 1, 5, 10, 30", m.Period))
+	}
+	if m.Duration < m.Period {
+		errs = append(errs, fmt.Errorf(
+			"duration %d is shorter than period %d", m.Duration, m.Period))
+	}
+	if m.Delay < 0 {
+		errs = append(errs, fmt.Errorf("delay must not be negative"))
+	}
+
+	return errs
+}
+
+// isPercentile returns true if statistic names a percentile between p0 and
+// p100.
+func isPercentile(statistic string) bool {
+	match := percentilePattern.FindStringSubmatch(statistic)
+	if match == nil {
+		return false
+	}
+	p, err := strconv.ParseFloat(match[1], 64)
+	return err == nil && p <= 100
+}
@@ -0,0 +1,113 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/naoina/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validMetric() Metric {
+	return Metric{
+		Region:      "us-east-1",
+		Namespace:   "AWS/ELB",
+		MetricNames: []string{"Latency"},
+		Statistics:  []string{"Average", "SampleCount"},
+		Period:      60,
+		Duration:    300,
+	}
+}
+
+func TestSampleConfig(t *testing.T) {
+	var config struct {
+		Cloudwatch CloudWatch
+	}
+	require.NoError(t, toml.Unmarshal(
+		[]byte("[cloudwatch]"+sampleConfig), &config))
+
+	cw := config.Cloudwatch
+	require.NoError(t, cw.Validate())
+	require.Len(t, cw.Metrics, 1)
+	assert.Equal(t, "AWS/ELB", cw.Metrics[0].Namespace)
+	assert.Equal(t, map[string]DimensionValues{
+		"LoadBalancerName": {"my-load-balancer", "my-other-load-balancer"},
+	}, cw.Metrics[0].Dimensions)
+}
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		change func(*Metric)
+		err    string
+	}{
+		{func(m *Metric) {}, ""},
+		{func(m *Metric) { m.Region = "" }, "region is not set"},
+		{func(m *Metric) { m.Namespace = "" }, "namespace is not set"},
+		{func(m *Metric) { m.Statistics = nil }, "no statistics configured"},
+		{func(m *Metric) {
+			m.Statistics = nil
+			m.ExtendedStatistics = []string{"p99.9"}
+		}, ""},
+		{func(m *Metric) { m.Statistics = []string{"Avg"} },
+			`unknown statistic "Avg"`},
+		{func(m *Metric) { m.ExtendedStatistics = []string{"p101"} },
+			`unknown extended statistic "p101"`},
+		{func(m *Metric) { m.ExtendedStatistics = []string{"median"} },
+			`unknown extended statistic "median"`},
+		{func(m *Metric) { m.Period = 0 }, "period must be positive"},
+		{func(m *Metric) { m.Period = 90 },
+			"period 90 is not a multiple of 60"},
+		{func(m *Metric) { m.Period, m.Duration = 10, 60 }, ""},
+		{func(m *Metric) { m.Period = 600 },
+			"duration 300 is shorter than period 600"},
+		{func(m *Metric) { m.Delay = -60 }, "delay must not be negative"},
+		{func(m *Metric) { m.MeasurementName = "{prefix}_{metric}_{stat}" }, ""},
+		{func(m *Metric) { m.MeasurementName = "{service}_{metric}" },
+			"unknown variable {service} in measurement_name"},
+		{func(m *Metric) { m.Region, m.Regions = "", []string{"eu-west-1"} }, ""},
+		{func(m *Metric) { m.Regions = []string{"eu-west-1", ""} },
+			"regions contains an empty region"},
+		{func(m *Metric) {
+			m.Dimensions = map[string]DimensionValues{"InstanceId": {}}
+		}, "dimension InstanceId has no values"},
+		{func(m *Metric) {
+			m.Dimensions = map[string]DimensionValues{"InstanceId": {"i-["}}
+		}, `dimension InstanceId: bad pattern "i-["`},
+	}
+
+	for _, test := range tests {
+		m := validMetric()
+		test.change(&m)
+		cw := &CloudWatch{Metrics: []Metric{m}}
+
+		err := cw.Validate()
+		if test.err == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "metrics[0]: "+test.err)
+		}
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	a := validMetric()
+	a.Region = ""
+	b := validMetric()
+	b.Statistics = []string{"Median"}
+	b.Period = 45
+	cw := &CloudWatch{Metrics: []Metric{a, b}}
+
+	err := cw.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 errors")
+	assert.Contains(t, err.Error(), "metrics[0]: region is not set")
+	assert.Contains(t, err.Error(), `metrics[1]: unknown statistic "Median"`)
+	assert.Contains(t, err.Error(), "metrics[1]: period 45")
+}
+
+func TestValidateNoMetrics(t *testing.T) {
+	cw := &CloudWatch{}
+	assert.Error(t, cw.Validate())
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"runtime"
+
+	"github.com/influxdb/telegraf/internal/selfstat"
+	"github.com/influxdb/telegraf/plugins"
+)
+
+// Internal reports the statistics the agent keeps about itself.
+type Internal struct {
+	CollectMemstats bool
+}
+
+var sampleConfig = `
+  # Report the Go runtime memory statistics of the agent as well
+  collect_memstats = true
+`
+
+// goroutines is reported with the other agent statistics
+var goroutines = selfstat.Register("agent", "goroutines", nil)
+
+func (i *Internal) SampleConfig() string {
+	return sampleConfig
+}
+
+func (i *Internal) Description() string {
+	return "Collect statistics about the telegraf agent itself"
+}
+
+func (i *Internal) Gather(acc plugins.Accumulator) error {
+	goroutines.Set(int64(runtime.NumGoroutine()))
+	for _, m := range selfstat.Metrics() {
+		acc.AddFields(m.Measurement, m.Fields, m.Tags)
+	}
+
+	if i.CollectMemstats {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		acc.AddFields("memstats", map[string]interface{}{
+			"alloc_bytes":         int64(ms.Alloc),
+			"total_alloc_bytes":   int64(ms.TotalAlloc),
+			"sys_bytes":           int64(ms.Sys),
+			"mallocs":             int64(ms.Mallocs),
+			"frees":               int64(ms.Frees),
+			"heap_alloc_bytes":    int64(ms.HeapAlloc),
+			"heap_sys_bytes":      int64(ms.HeapSys),
+			"heap_idle_bytes":     int64(ms.HeapIdle),
+			"heap_in_use_bytes":   int64(ms.HeapInuse),
+			"heap_released_bytes": int64(ms.HeapReleased),
+			"heap_objects":        int64(ms.HeapObjects),
+			"num_gc":              int64(ms.NumGC),
+			"pause_total_ns":      int64(ms.PauseTotalNs),
+		}, nil)
+	}
+	return nil
+}
+
+func init() {
+	plugins.Add("internal", func() plugins.Plugin {
+		return &Internal{CollectMemstats: true}
+	})
+}
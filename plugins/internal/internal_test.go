@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/influxdb/telegraf/internal/selfstat"
+	"github.com/influxdb/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGather(t *testing.T) {
+	selfstat.Register("gather", "errors", map[string]string{"plugin": "cpu"}).
+		Incr(2)
+
+	i := &Internal{CollectMemstats: true}
+	var acc testutil.Accumulator
+	require.NoError(t, i.Gather(&acc))
+
+	assert.NoError(t, acc.ValidateTaggedFieldsValue("gather",
+		map[string]interface{}{"errors": int64(2)},
+		map[string]string{"plugin": "cpu"}))
+	agent, ok := acc.Get("agent")
+	require.True(t, ok)
+	assert.True(t, agent.Fields["goroutines"].(int64) > 0)
+	assert.True(t, acc.HasMeasurement("memstats"))
+}
+
+func TestGatherWithoutMemstats(t *testing.T) {
+	i := &Internal{}
+	var acc testutil.Accumulator
+	require.NoError(t, i.Gather(&acc))
+
+	assert.True(t, acc.HasMeasurement("agent"))
+	assert.False(t, acc.HasMeasurement("memstats"))
+}
@@ -29,6 +29,22 @@ type Plugin interface {
 	Gather(Accumulator) error
 }
 
+// Validator is implemented by plugins that check their configuration before
+// the agent starts.
+type Validator interface {
+	// Validate returns an error describing what is wrong with the plugin's
+	// configuration, or nil if it is usable
+	Validate() error
+}
+
+// Backfiller is implemented by plugins that can gather a past time range on
+// demand, e.g. to fill a gap left while the agent was down.
+type Backfiller interface {
+	// Backfill adds the metrics from start to end to the accumulator, with
+	// their original timestamps
+	Backfill(acc Accumulator, start, end time.Time) error
+}
+
 type ServicePlugin interface {
 	// SampleConfig returns the default configuration of the Plugin
 	SampleConfig() string
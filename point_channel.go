@@ -0,0 +1,110 @@
+package telegraf
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+// Policies applied when the point channel is full.
+const (
+	// policyBlock makes the plugins wait until there is room, stalling
+	// collection while the outputs catch up.
+	policyBlock = "block"
+	// policyDropNewest drops the points sent while the channel is full.
+	policyDropNewest = "drop_newest"
+	// policyDropOldest drops the oldest queued points to make room.
+	policyDropOldest = "drop_oldest"
+)
+
+// pointChannel carries the points from the plugins to the outputs. It holds
+// at most size points, and applies its policy to points sent while full.
+type pointChannel struct {
+	points chan *client.Point
+	policy string
+
+	// Counters, updated atomically
+	sent    int64
+	dropped int64
+	blocked int64
+}
+
+// pointChannelStats describes the traffic through a point channel.
+type pointChannelStats struct {
+	// queued is the number of points waiting to be handed to the outputs.
+	queued int64
+	// sent counts all points sent, dropped the points dropped by the
+	// policy, and blocked the sends that had to wait for room.
+	sent    int64
+	dropped int64
+	blocked int64
+}
+
+func newPointChannel(size int, policy string) (*pointChannel, error) {
+	if err := validatePointPolicy(policy); err != nil {
+		return nil, err
+	}
+	if size < 0 || (size == 0 && policy != policyBlock) {
+		return nil, fmt.Errorf("point_buffer_size must be positive")
+	}
+	return &pointChannel{
+		points: make(chan *client.Point, size),
+		policy: policy,
+	}, nil
+}
+
+func validatePointPolicy(policy string) error {
+	switch policy {
+	case policyBlock, policyDropNewest, policyDropOldest:
+		return nil
+	}
+	return fmt.Errorf("unknown point_buffer_policy %q, expected %q, %q or %q",
+		policy, policyBlock, policyDropNewest, policyDropOldest)
+}
+
+// send queues pt, applying the policy if the channel is full.
+func (c *pointChannel) send(pt *client.Point) {
+	atomic.AddInt64(&c.sent, 1)
+	select {
+	case c.points <- pt:
+		return
+	default:
+	}
+
+	switch c.policy {
+	case policyDropNewest:
+		atomic.AddInt64(&c.dropped, 1)
+	case policyDropOldest:
+		for {
+			select {
+			case c.points <- pt:
+				return
+			default:
+			}
+			// Other senders may race for the room made, so try again
+			select {
+			case <-c.points:
+				atomic.AddInt64(&c.dropped, 1)
+			default:
+			}
+		}
+	default:
+		atomic.AddInt64(&c.blocked, 1)
+		c.points <- pt
+	}
+}
+
+// close tells the receiver that no more points will be sent.
+func (c *pointChannel) close() {
+	close(c.points)
+}
+
+func (c *pointChannel) stats() pointChannelStats {
+	return pointChannelStats{
+		queued:  int64(len(c.points)),
+		sent:    atomic.LoadInt64(&c.sent),
+		dropped: atomic.LoadInt64(&c.dropped),
+		blocked: atomic.LoadInt64(&c.blocked),
+	}
+}
@@ -0,0 +1,66 @@
+package telegraf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPointChannel_Invalid(t *testing.T) {
+	_, err := newPointChannel(10, "drop_random")
+	assert.Error(t, err)
+	_, err = newPointChannel(-1, policyBlock)
+	assert.Error(t, err)
+	_, err = newPointChannel(0, policyDropOldest)
+	assert.Error(t, err)
+}
+
+func TestPointChannel_DropNewest(t *testing.T) {
+	c, err := newPointChannel(2, policyDropNewest)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		c.send(testPoint(t, i))
+	}
+
+	assert.Equal(t, pointChannelStats{queued: 2, sent: 5, dropped: 3},
+		c.stats())
+	assert.Equal(t, int64(0), (<-c.points).Fields()["value"])
+	assert.Equal(t, int64(1), (<-c.points).Fields()["value"])
+}
+
+func TestPointChannel_DropOldest(t *testing.T) {
+	c, err := newPointChannel(2, policyDropOldest)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		c.send(testPoint(t, i))
+	}
+
+	assert.Equal(t, pointChannelStats{queued: 2, sent: 5, dropped: 3},
+		c.stats())
+	assert.Equal(t, int64(3), (<-c.points).Fields()["value"])
+	assert.Equal(t, int64(4), (<-c.points).Fields()["value"])
+}
+
+func TestPointChannel_Block(t *testing.T) {
+	c, err := newPointChannel(1, policyBlock)
+	require.NoError(t, err)
+	c.send(testPoint(t, 0))
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		c.send(testPoint(t, 1))
+	}()
+	select {
+	case <-sent:
+		t.Fatal("send did not block on a full channel")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	assert.Equal(t, int64(0), (<-c.points).Fields()["value"])
+	<-sent
+	assert.Equal(t, int64(1), (<-c.points).Fields()["value"])
+	assert.Equal(t, pointChannelStats{sent: 2, blocked: 1}, c.stats())
+}
@@ -0,0 +1,240 @@
+package telegraf
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/influxdb/telegraf/internal/buffer"
+	"github.com/influxdb/telegraf/internal/selfstat"
+	"github.com/influxdb/telegraf/outputs"
+
+	"github.com/influxdb/influxdb/client/v2"
+)
+
+type runningOutput struct {
+	name   string
+	output outputs.Output
+	config *ConfiguredOutput
+
+	// buffer holds the points gathered since the last flush, and the points
+	// the output did not accept when it has no disk buffer.
+	buffer    *buffer.Buffer
+	batchSize int
+
+	// queue holds the batches not accepted by the output yet, nil if the
+	// output has no disk buffer.
+	queue *buffer.DiskQueue
+
+	// The mutex serialises the writes, so that a batch is never written
+	// twice.
+	sync.Mutex
+
+	// failures counts the consecutive failed flushes and skip the flushes
+	// left to skip before trying again.
+	failures int
+	skip     int
+
+	// Self statistics, reported by the internal plugin
+	writeTime      *selfstat.Stat
+	writeErrors    *selfstat.Stat
+	batchWritten   *selfstat.Stat
+	metricsWritten *selfstat.Stat
+	metricsDropped *selfstat.Stat
+	bufferSize     *selfstat.Stat
+	bufferLimit    *selfstat.Stat
+}
+
+// newRunningOutput returns the output called name, with its buffers set up
+// from config.
+func newRunningOutput(
+	name string,
+	output outputs.Output,
+	config *ConfiguredOutput,
+) (*runningOutput, error) {
+	if config == nil {
+		config = &ConfiguredOutput{Name: name}
+	}
+	limit := config.MetricBufferLimit
+	if limit == 0 {
+		limit = defaultMetricBufferLimit
+	}
+	batchSize := config.MetricBatchSize
+	if batchSize == 0 {
+		batchSize = defaultMetricBatchSize
+	}
+
+	tags := map[string]string{"output": name}
+	ro := &runningOutput{
+		name:      name,
+		output:    output,
+		config:    config,
+		buffer:    buffer.NewBuffer(limit),
+		batchSize: batchSize,
+
+		writeTime:      selfstat.Register("write", "write_time_ns", tags),
+		writeErrors:    selfstat.Register("write", "errors", tags),
+		batchWritten:   selfstat.Register("write", "batch_size", tags),
+		metricsWritten: selfstat.Register("write", "metrics_written", tags),
+		metricsDropped: selfstat.Register("write", "metrics_dropped", tags),
+		bufferSize:     selfstat.Register("write", "buffer_size", tags),
+		bufferLimit:    selfstat.Register("write", "buffer_limit", tags),
+	}
+	ro.bufferLimit.Set(int64(limit))
+
+	if config.DiskBufferPath != "" {
+		maxMB := config.DiskBufferMaxMB
+		if maxMB == 0 {
+			maxMB = defaultDiskBufferMaxMB
+		}
+		queue, err := buffer.NewDiskQueue(config.DiskBufferPath, maxMB<<20)
+		if err != nil {
+			return nil, fmt.Errorf("Error in output [%s]: %s", name, err)
+		}
+		if n := queue.Len(); n > 0 {
+			log.Printf("Output [%s] has %d batches left on disk to write\n",
+				name, n)
+		}
+		ro.queue = queue
+	}
+	return ro, nil
+}
+
+// add buffers points until the next flush.
+func (ro *runningOutput) add(points ...*client.Point) {
+	ro.buffer.Add(points...)
+}
+
+// write writes the buffered points in batches, oldest first, and stops at
+// the first batch the output does not accept, which is retried at the next
+// flush. Once shutdown is closed, an output with a disk buffer leaves the
+// batches it has not written on disk for the next run.
+func (ro *runningOutput) write(shutdown chan struct{}) error {
+	ro.Lock()
+	defer ro.Unlock()
+	defer ro.updateBufferStats()
+
+	if ro.queue != nil {
+		return ro.writeQueued(shutdown)
+	}
+	for {
+		batch, first := ro.buffer.Batch(ro.batchSize)
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := ro.writeBatch(batch); err != nil {
+			return err
+		}
+		ro.buffer.Ack(first, len(batch))
+	}
+}
+
+// writeQueued moves the buffered points to the disk buffer, then writes the
+// batches of the disk buffer.
+func (ro *runningOutput) writeQueued(shutdown chan struct{}) error {
+	for {
+		batch, first := ro.buffer.Batch(ro.batchSize)
+		if len(batch) == 0 {
+			break
+		}
+		if err := ro.queue.Push(batch); err != nil {
+			// Keep the points in memory until the disk is usable again
+			return fmt.Errorf("could not buffer %d metrics on disk: %s",
+				len(batch), err)
+		}
+		ro.buffer.Ack(first, len(batch))
+	}
+
+	for {
+		id, batch, err := ro.queue.Peek()
+		if err != nil {
+			log.Printf("Error in output [%s]: dropping unreadable buffered "+
+				"batch: %s\n", ro.name, err)
+			if err := ro.queue.Remove(id); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := ro.writeBatch(batch); err != nil {
+			return err
+		}
+		if err := ro.queue.Remove(id); err != nil {
+			return err
+		}
+
+		select {
+		case <-shutdown:
+			return nil
+		default:
+		}
+	}
+}
+
+// writeBatch writes a batch to the output, recording how it went.
+func (ro *runningOutput) writeBatch(batch []*client.Point) error {
+	start := time.Now()
+	if err := ro.output.Write(batch); err != nil {
+		ro.writeErrors.Incr(1)
+		return err
+	}
+	elapsed := time.Since(start)
+	ro.writeTime.Set(elapsed.Nanoseconds())
+	ro.batchWritten.Set(int64(len(batch)))
+	ro.metricsWritten.Incr(int64(len(batch)))
+	log.Printf("Flushed %d metrics to output %s in %s\n",
+		len(batch), ro.name, elapsed)
+	return nil
+}
+
+// updateBufferStats records how full the buffers of the output are, and how
+// many points they dropped.
+func (ro *runningOutput) updateBufferStats() {
+	ro.bufferSize.Set(ro.buffered())
+	ro.metricsDropped.Set(ro.dropped())
+}
+
+// buffered returns the number of points waiting to be written.
+func (ro *runningOutput) buffered() int64 {
+	n := int64(ro.buffer.Len())
+	if ro.queue != nil {
+		n += ro.queue.Stats().Points
+	}
+	return n
+}
+
+// dropped returns the number of points the buffers of the output dropped
+// since the start.
+func (ro *runningOutput) dropped() int64 {
+	n := ro.buffer.Dropped()
+	if ro.queue != nil {
+		n += ro.queue.Stats().Evicted
+	}
+	return n
+}
+
+// flushFailed records a failed flush and returns the number of flushes to
+// skip before the next try: none after the first failure, then twice as
+// many after each one, up to maxSkip.
+func (ro *runningOutput) flushFailed(maxSkip int) int {
+	ro.failures++
+	skip := 0
+	for i := 1; i < ro.failures && skip < maxSkip; i++ {
+		skip = 2*skip + 1
+	}
+	if skip > maxSkip {
+		skip = maxSkip
+	}
+	ro.skip = skip
+	return skip
+}
+
+// flushSucceeded resets the backoff after a successful flush.
+func (ro *runningOutput) flushSucceeded() {
+	ro.failures = 0
+	ro.skip = 0
+}